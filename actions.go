@@ -0,0 +1,105 @@
+package graceful
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// actionKind is what an Action does
+type actionKind int
+
+const (
+	actionUpgrade actionKind = iota + 1
+	actionShutdown
+	actionImmediate
+	actionFunc
+)
+
+// Action is what WithSignalActions does when a signal is received
+type Action struct {
+	kind actionKind
+	fn   func()
+}
+
+// Signal actions
+var (
+	// ActionUpgrade upgrades gracefully, like the WithUpgradeSignals
+	ActionUpgrade = Action{kind: actionUpgrade}
+	// ActionShutdown shuts down gracefully, like the WithShutdownSignals
+	ActionShutdown = Action{kind: actionShutdown}
+	// ActionImmediateShutdown shuts down closing every connection at once
+	ActionImmediateShutdown = Action{kind: actionImmediate}
+)
+
+// ActionFunc returns an Action running fn, like WithSignalHandler, such as
+// to reopen log files or dump state
+func ActionFunc(fn func()) Action {
+	return Action{kind: actionFunc, fn: fn}
+}
+
+// DefaultSignalActions returns the actions used without WithSignalActions:
+// SIGINT and SIGTERM shut down gracefully and, where upgrades are supported,
+// SIGHUP upgrades. The map is new on each call, so it can be changed before
+// it is passed to WithSignalActions.
+func DefaultSignalActions() map[os.Signal]Action {
+	actions := make(map[os.Signal]Action)
+	for _, sig := range defaultShutdownSignals {
+		actions[sig] = ActionShutdown
+	}
+	for _, sig := range defaultUpgradeSignals {
+		actions[sig] = ActionUpgrade
+	}
+
+	return actions
+}
+
+// InteractiveSignalActions returns DefaultSignalActions with SIGINT, as sent
+// by Ctrl-C in a terminal, shutting down immediately for fast local
+// iteration, while SIGTERM from a supervisor still drains gracefully
+func InteractiveSignalActions() map[os.Signal]Action {
+	actions := DefaultSignalActions()
+	actions[os.Interrupt] = ActionImmediateShutdown
+
+	return actions
+}
+
+// resolveSignalActions replaces the shutdown and upgrade signals with those
+// of the WithSignalActions map and adds its handlers
+func (s *Server) resolveSignalActions() error {
+	if s.signalActions == nil {
+		return nil
+	}
+
+	var sigs []os.Signal
+	for sig := range s.signalActions {
+		sigs = append(sigs, sig)
+	}
+	// Register in a stable order
+	sort.Slice(sigs, func(i, j int) bool { return signalName(sigs[i]) < signalName(sigs[j]) })
+
+	s.shutdownSignals, s.upgradeSignals, s.immediateSignals = nil, nil, nil
+	for _, sig := range sigs {
+		action := s.signalActions[sig]
+
+		switch action.kind {
+		case actionUpgrade:
+			s.upgradeSignals = append(s.upgradeSignals, sig)
+		case actionShutdown:
+			s.shutdownSignals = append(s.shutdownSignals, sig)
+		case actionImmediate:
+			s.shutdownSignals = append(s.shutdownSignals, sig)
+			s.immediateSignals = append(s.immediateSignals, sig)
+		case actionFunc:
+			if action.fn == nil {
+				return fmt.Errorf("graceful: the action for %s has a nil function", signalName(sig))
+			}
+			s.signalHandlers = append(s.signalHandlers, signalHandler{sig: sig, fn: action.fn})
+		default:
+			return fmt.Errorf("graceful: %s has no action", signalName(sig))
+		}
+	}
+	s.signalActions = nil
+
+	return nil
+}
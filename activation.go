@@ -0,0 +1,114 @@
+package graceful
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation
+const listenFdsStart = 3
+
+// activatedListener is a listener passed in by systemd socket activation
+type activatedListener struct {
+	name string
+	ln   net.Listener
+}
+
+// activatedListeners adopts the listeners passed via LISTEN_FDS, or returns
+// nil when the process wasn't socket activated. The LISTEN_* variables are
+// cleared so upgraded processes don't try to adopt the same fds.
+func activatedListeners() ([]*activatedListener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]*activatedListener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.ln.Close()
+			}
+
+			return nil, err
+		}
+
+		l := &activatedListener{ln: ln}
+		if i < len(names) {
+			l.name = names[i]
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// takeActivated removes and returns the activated listener for address, if any
+func (s *Server) takeActivated(network, address string) net.Listener {
+	for i, l := range s.activated {
+		if l.name == address || addrMatches(network, address, l.ln.Addr()) {
+			s.activated = append(s.activated[:i], s.activated[i+1:]...)
+			return l.ln
+		}
+	}
+
+	return nil
+}
+
+// closeActivated closes the activated listeners no server claimed
+func (s *Server) closeActivated() {
+	for _, l := range s.activated {
+		s.logger.Warnf("Closing unused socket activated listener [%s]", l.ln.Addr())
+		_ = l.ln.Close()
+	}
+
+	s.activated = nil
+}
+
+// addrMatches reports whether a listener bound to addr serves address
+func addrMatches(network, address string, addr net.Addr) bool {
+	if network == "unix" {
+		return addr.Network() == "unix" && addr.String() == address
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	lhost, lport, err := net.SplitHostPort(addr.String())
+	if err != nil || port != lport {
+		return false
+	}
+
+	if host == "" {
+		return true
+	}
+
+	ip, lip := net.ParseIP(host), net.ParseIP(lhost)
+	if ip != nil && lip != nil {
+		return ip.Equal(lip) || lip.IsUnspecified()
+	}
+
+	return host == lhost
+}
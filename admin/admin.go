@@ -0,0 +1,54 @@
+// Package admin runs an admin http server alongside a graceful.Server, with
+// pprof and the server's health, drain status and upgrade handlers. It is a
+// separate package because importing net/http/pprof also registers its
+// handlers on http.DefaultServeMux.
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/codechimp-io/graceful"
+)
+
+// Priority is the shutdown priority of the admin server. It drains after
+// the servers with the default priority, so their drain can be watched.
+const Priority = 1
+
+// WithServer serves the admin endpoints on addr, on a listener inherited
+// across upgrades like the server's own:
+//
+//	/debug/pprof/  the net/http/pprof profiles
+//	/health        the HealthHandler readiness probe
+//	/drain         the DrainStatusHandler report
+//	/upgrade       the UpgradeHandler, on POST
+//
+// The endpoints aren't authenticated, so addr shouldn't be reachable publicly.
+func WithServer(addr string) graceful.Option {
+	return func(s *graceful.Server) {
+		srv := &http.Server{
+			Addr:    addr,
+			Handler: Handler(s),
+		}
+
+		s.RegisterWithPriority(Priority, addr, srv)
+	}
+}
+
+// Handler returns the admin endpoints of s, for mounting on a server of
+// the caller's own
+func Handler(s *graceful.Server) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.Handle("/health", s.HealthHandler())
+	mux.Handle("/drain", s.DrainStatusHandler())
+	mux.Handle("/upgrade", s.UpgradeHandler())
+
+	return mux
+}
@@ -0,0 +1,142 @@
+package graceful
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveClock replaces the clock of s by a fakeClock and runs serve, returning
+// the clock and a channel serve returns on
+func serveClock(s *Server, serve func() error) (*fakeClock, <-chan error) {
+	clock := newFakeClock()
+	s.clock = clock
+
+	errc := make(chan error, 1)
+	go func() { errc <- serve() }()
+
+	return clock, errc
+}
+
+// waitCause waits for Serve to return and checks the cause of the shutdown
+func waitCause(t *testing.T, s *Server, errc <-chan error, want ShutdownCause) error {
+	var err error
+	select {
+	case err = <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve didn't return on %s", want)
+	}
+
+	if cause := s.ShutdownCause(); cause != want {
+		t.Errorf("ShutdownCause() = %q, want %q", cause, want)
+	}
+
+	return err
+}
+
+func TestSelfHealthCheckCause(t *testing.T) {
+	checks := make(chan struct{})
+	s := newTestServer(t,
+		WithSelfHealthCheck(time.Minute, func() error {
+			checks <- struct{}{}
+			return errors.New("unhealthy")
+		}),
+		WithSelfHealthCheckFailures(2),
+	)
+	ln := listen(t)
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	clock, errc := serveClock(s, func() error { return s.ServeListener(ln, srv) })
+
+	<-s.ready
+	clock.BlockUntil(1)
+
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Minute)
+		<-checks
+	}
+
+	err := waitCause(t, s, errc, CauseSelfHealthCheck)
+	if err == nil {
+		t.Error("Serve returned nil after failing self health checks")
+	}
+}
+
+func TestStartupTimeoutCause(t *testing.T) {
+	release := make(chan struct{})
+	s := newTestServer(t,
+		WithStartupTimeout(time.Minute),
+		WithReadyCallback(func() { <-release }),
+	)
+	ln := listen(t)
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	clock, errc := serveClock(s, func() error { return s.ServeListener(ln, srv) })
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+
+	select {
+	case <-s.Draining():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The startup timeout didn't start the drain")
+	}
+	close(release)
+
+	err := waitCause(t, s, errc, CauseStartupTimeout)
+	if err == nil {
+		t.Error("Serve returned nil after the startup timeout")
+	}
+}
+
+func TestReloadFileCause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reload")
+
+	u := newFakeUpgrader()
+	s := newTestServer(t,
+		WithUpgrades(true),
+		WithUpgrader(func(UpgraderOptions) (Upgrader, error) {
+			return u, nil
+		}),
+		WithReloadFile(path),
+	)
+	srv := &http.Server{
+		Addr:    "127.0.0.1:0",
+		Handler: http.NotFoundHandler(),
+	}
+	clock, errc := serveClock(s, func() error { return s.Serve(srv) })
+
+	<-s.ready
+	clock.BlockUntil(1)
+
+	err = ioutil.WriteFile(path, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The change is acted on once the file stayed the same for a poll
+	deadline := time.After(5 * time.Second)
+	for upgraded := false; !upgraded; {
+		clock.Advance(reloadPollInterval)
+
+		select {
+		case <-u.upgrades:
+			upgraded = true
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Changing the reload file didn't upgrade")
+		}
+	}
+
+	err = waitCause(t, s, errc, CauseUpgrade)
+	if err != nil {
+		t.Errorf("Serve returned %v after the upgrade, want nil", err)
+	}
+}
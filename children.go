@@ -0,0 +1,109 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/codechimp-io/graceful/internal/drain"
+)
+
+// errProcessGone is returned by signalProcess for a process that has exited
+var errProcessGone = errors.New("process has exited")
+
+// RegisterChild adds pid to the child processes that WithChildSignaling
+// signals and waits for on shutdown. Children that have already exited by
+// then are skipped.
+func (s *Server) RegisterChild(pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.children == nil {
+		s.children = make(map[int]struct{})
+	}
+	s.children[pid] = struct{}{}
+}
+
+// signalChildren forwards the shutdown signal to the registered children,
+// forgetting those that have exited
+func (s *Server) signalChildren() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sig := s.shutdownSignal
+	if sig == nil {
+		sig = defaultChildSignal
+	}
+
+	// After an upgrade the PID file names the new process, which must keep
+	// serving even if it was registered by mistake
+	upgraded := s.readPIDFile()
+
+	for pid := range s.children {
+		if pid == os.Getpid() || pid == upgraded {
+			delete(s.children, pid)
+			continue
+		}
+
+		err := signalProcess(pid, sig)
+		switch {
+		case errors.Is(err, errProcessGone):
+			delete(s.children, pid)
+		case err != nil:
+			s.logger.Errorf("Signalling child process %d failed: %v", pid, err)
+			s.emit(Event{Type: EventError, Err: err})
+			delete(s.children, pid)
+		default:
+			s.logger.Infof("Sent %s to child process %d", signalName(sig), pid)
+		}
+	}
+}
+
+// waitChildren waits for the signalled children to exit, within the shutdown
+// timeout, if any, and ctx
+func (s *Server) waitChildren(ctx context.Context) {
+	ctx, cancel := drain.Context(ctx, s.shutdownTimeout)
+	defer cancel()
+
+	ticker := s.clock.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		left := s.runningChildren()
+		if len(left) == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			if s.shutdownTimeout > 0 {
+				s.logger.Warnf("Child processes %v didn't exit within %s, no longer waiting for them", left, s.shutdownTimeout)
+			} else {
+				s.logger.Warnf("Child processes %v didn't exit before the shutdown ended, no longer waiting for them", left)
+			}
+			return
+		}
+	}
+}
+
+// runningChildren forgets the children that have exited and returns the
+// others in order
+func (s *Server) runningChildren() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pids []int
+	for pid := range s.children {
+		if !processAlive(pid) {
+			delete(s.children, pid)
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	return pids
+}
@@ -0,0 +1,75 @@
+package graceful
+
+import (
+	"context"
+	"time"
+)
+
+// clock is the time source of the lifecycle: the startup and shutdown
+// deadlines, the drain delays and timing, connection and request activity,
+// the idle timeout, accept limiting, upgrade throttling, listener reloads,
+// worker, child and hijacked connection timeouts and the periodic checks. It
+// is the real time package outside tests, which set Server.clock to a
+// fakeClock they advance by hand to check those deterministically.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) timer
+	NewTicker(d time.Duration) ticker
+}
+
+// timer is a *time.Timer of a clock
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// ticker is a *time.Ticker of a clock
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// since returns the time elapsed on c since t
+func since(c clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// withTimeout returns a copy of ctx that is cancelled after d on c. On the
+// real clock it is context.WithTimeout, so network calls see the deadline.
+func withTimeout(ctx context.Context, c clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t := c.NewTimer(d)
+	go func() {
+		defer t.Stop()
+
+		select {
+		case <-t.C():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,137 @@
+package graceful
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock whose time only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+	// changed is signalled whenever a timer is armed or stopped
+	changed *sync.Cond
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{now: time.Unix(0, 0)}
+	c.changed = sync.NewCond(&c.mu)
+
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+
+	return t
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+
+	return fakeTicker{t}
+}
+
+// Advance moves the time forward by d, firing the timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	var pending []*fakeTimer
+	for _, t := range c.waiters {
+		if t.deadline.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		if t.period > 0 {
+			t.deadline = c.now.Add(t.period)
+			pending = append(pending, t)
+		}
+	}
+	c.waiters = pending
+	c.changed.Broadcast()
+}
+
+// BlockUntil waits until n timers or tickers are armed
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.waiters) != n {
+		c.changed.Wait()
+	}
+}
+
+// remove disarms t, reporting whether it was armed. c.mu must be held.
+func (c *fakeClock) remove(t *fakeTimer) bool {
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// fakeTimer is a timer, or a ticker when period is set, of a fakeClock
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	armed := t.clock.remove(t)
+	t.clock.changed.Broadcast()
+
+	return armed
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	armed := t.clock.remove(t)
+	t.deadline = t.clock.now.Add(d)
+	t.clock.waiters = append(t.clock.waiters, t)
+	t.clock.changed.Broadcast()
+
+	return armed
+}
+
+// fakeTicker is a ticker of a fakeClock
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
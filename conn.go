@@ -0,0 +1,69 @@
+package graceful
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connTracker counts the open connections of a server
+type connTracker struct {
+	mu    sync.Mutex
+	clock clock
+	conns map[net.Conn]http.ConnState
+	// active is when a connection last opened, closed or started a request
+	active time.Time
+	// requests counts the requests started on HTTP/1 connections
+	requests uint64
+}
+
+// track records a connection state transition
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Idle keep-alive connections don't count as activity
+	if state != http.StateIdle {
+		t.active = t.clock.Now()
+	}
+
+	if state == http.StateActive {
+		t.requests++
+	}
+
+	switch state {
+	case http.StateNew, http.StateActive, http.StateIdle:
+		if t.conns == nil {
+			t.conns = make(map[net.Conn]http.ConnState)
+		}
+		t.conns[c] = state
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, c)
+	}
+}
+
+// count returns the number of open connections
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// idle returns the number of open connections and when the tracker was last
+// active
+func (t *connTracker) idle() (int, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns), t.active
+}
+
+// served returns the number of requests started
+func (t *connTracker) served() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.requests
+}
@@ -0,0 +1,173 @@
+// Package consulgraceful registers a graceful.Server's service in Consul
+// while it serves. It lives in its own module so servers that don't use
+// Consul don't depend on it.
+//
+// It is named like otelgraceful and promgraceful rather than graceful/consul,
+// so it doesn't shadow the consul name callers give the Consul API package.
+package consulgraceful
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/codechimp-io/graceful"
+	"github.com/hashicorp/consul/api"
+)
+
+// Register registers service with the Consul agent of client once the servers
+// are ready, and deregisters it in a pre-shutdown hook so discovery stops
+// sending traffic before the drain; give it ahead of other WithPreShutdown
+// hooks for it to run first. TTL checks of the service are kept passing at
+// half their TTL while serving. A failed registration shuts the server down
+// with its error.
+func Register(client *api.Client, service *api.AgentServiceRegistration) graceful.Option {
+	r := &registration{
+		agent:   client.Agent(),
+		service: service,
+		ready:   make(chan struct{}),
+	}
+
+	opts := []graceful.Option{
+		graceful.WithReadyCallback(func() { close(r.ready) }),
+		graceful.WithWorkers(r.run),
+		graceful.WithPreShutdown(r.deregister),
+	}
+
+	return func(s *graceful.Server) {
+		for _, opt := range opts {
+			opt(s)
+		}
+	}
+}
+
+// registration is the Consul lifecycle of one service
+type registration struct {
+	agent   *api.Agent
+	service *api.AgentServiceRegistration
+	ready   chan struct{}
+
+	mu           sync.Mutex
+	registered   bool
+	deregistered bool
+}
+
+// id returns the service ID, which Consul defaults to the name
+func (r *registration) id() string {
+	if r.service.ID != "" {
+		return r.service.ID
+	}
+
+	return r.service.Name
+}
+
+// run registers the service once ready and updates its TTL checks until ctx
+// is done
+func (r *registration) run(ctx context.Context) error {
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		return nil
+	}
+
+	r.mu.Lock()
+	if r.deregistered {
+		r.mu.Unlock()
+		return nil
+	}
+	err := r.agent.ServiceRegister(r.service)
+	r.registered = err == nil
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("registering service %s in consul: %w", r.id(), err)
+	}
+
+	checks, interval := r.ttlChecks()
+	if len(checks) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.passChecks(checks)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// ttlChecks returns the IDs of the service's TTL checks and how often to
+// update them, half the shortest TTL
+func (r *registration) ttlChecks() ([]string, time.Duration) {
+	all := r.service.Checks
+	if r.service.Check != nil {
+		all = append(api.AgentServiceChecks{r.service.Check}, all...)
+	}
+
+	var (
+		ids      []string
+		interval time.Duration
+	)
+	for i, check := range all {
+		ttl, err := time.ParseDuration(check.TTL)
+		if err != nil || ttl <= 0 {
+			continue
+		}
+
+		id := check.CheckID
+		if id == "" {
+			// The IDs Consul gives the checks of a service
+			id = "service:" + r.id()
+			if len(all) > 1 {
+				id = fmt.Sprintf("%s:%d", id, i+1)
+			}
+		}
+		ids = append(ids, id)
+
+		if interval == 0 || ttl/2 < interval {
+			interval = ttl / 2
+		}
+	}
+
+	return ids, interval
+}
+
+// passChecks marks the TTL checks passing unless the service is deregistered.
+// A missed update only fails the check, so errors are dropped.
+func (r *registration) passChecks(checks []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.deregistered {
+		return
+	}
+
+	for _, id := range checks {
+		_ = r.agent.UpdateTTL(id, "", api.HealthPassing)
+	}
+}
+
+// deregister removes the service from Consul if it was registered
+func (r *registration) deregister(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deregistered = true
+	if !r.registered {
+		return nil
+	}
+
+	err := r.agent.ServiceDeregister(r.id())
+	if err != nil {
+		return fmt.Errorf("deregistering service %s from consul: %w", r.id(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+module github.com/codechimp-io/graceful/consulgraceful
+
+go 1.14
+
+require (
+	github.com/codechimp-io/graceful v0.0.0-20261014054804-794322e7ef25
+	github.com/hashicorp/consul/api v1.8.1
+)
+
+// Builds within this repository use the parent module as checked out. Modules
+// depending on this one ignore the replace and use the version required above.
+replace github.com/codechimp-io/graceful => ../
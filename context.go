@@ -0,0 +1,37 @@
+package graceful
+
+import (
+	"context"
+	"net"
+)
+
+// chainBaseContext returns a BaseContext that keeps the values and
+// cancellation of the caller's base, if any, and is also cancelled when
+// graceful shutdown starts
+func (s *Server) chainBaseContext(base func(net.Listener) context.Context) func(net.Listener) context.Context {
+	if base == nil {
+		return func(net.Listener) context.Context {
+			return s.baseCtx
+		}
+	}
+
+	return func(ln net.Listener) context.Context {
+		return mergeCancel(base(ln), s.baseCtx)
+	}
+}
+
+// mergeCancel returns a context carrying parent's values and deadline that is
+// also cancelled when other is done
+func mergeCancel(parent, other context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+
+	go func() {
+		select {
+		case <-other.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	return ctx
+}
@@ -0,0 +1,81 @@
+package graceful
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inflightRequest is a request tracked by WithDrainDiagnostics
+type inflightRequest struct {
+	method string
+	path   string
+	start  time.Time
+}
+
+// requestTracker holds the requests a server is handling
+type requestTracker struct {
+	mu       sync.Mutex
+	clock    clock
+	next     uint64
+	requests map[uint64]inflightRequest
+}
+
+// add registers r and returns the function removing it again
+func (t *requestTracker) add(r *http.Request) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.requests == nil {
+		t.requests = make(map[uint64]inflightRequest)
+	}
+	id := t.next
+	t.next++
+	t.requests[id] = inflightRequest{method: r.Method, path: r.URL.Path, start: t.clock.Now()}
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		delete(t.requests, id)
+	}
+}
+
+// summary describes the requests still running, longest first, such as
+// "GET /export (28s), POST /report (12s)"
+func (t *requestTracker) summary() (int, string) {
+	t.mu.Lock()
+	requests := make([]inflightRequest, 0, len(t.requests))
+	for _, r := range t.requests {
+		requests = append(requests, r)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].start.Before(requests[j].start) })
+
+	parts := make([]string, len(requests))
+	for i, r := range requests {
+		parts[i] = fmt.Sprintf("%s %s (%s)", r.method, r.path, since(t.clock, r.start).Round(time.Second))
+	}
+
+	return len(requests), strings.Join(parts, ", ")
+}
+
+// trackRequests makes m's server record its in-flight requests for the
+// summary logged when a drain times out
+func (s *Server) trackRequests(m *managed) {
+	next := m.http.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	m.http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := m.requests.add(r)
+		defer done()
+
+		next.ServeHTTP(w, r)
+	})
+}
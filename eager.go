@@ -0,0 +1,111 @@
+package graceful
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connKey is the context key for the connection a request arrived on
+type connKey struct{}
+
+// eagerTracker holds the connections registered with RegisterEagerClose
+type eagerTracker struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+// add registers c, or reports false once the connections have been closed
+func (t *eagerTracker) add(c net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closing {
+		return false
+	}
+
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[c] = struct{}{}
+
+	return true
+}
+
+func (t *eagerTracker) remove(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, c)
+}
+
+// closeAll closes the registered connections and those registered later,
+// returning how many it closed
+func (t *eagerTracker) closeAll() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closing = true
+	for c := range t.conns {
+		_ = c.Close()
+	}
+	n := len(t.conns)
+	t.conns = nil
+
+	return n
+}
+
+// RegisterEagerClose registers c, such as the long-lived stream of a
+// continuous profiler, to be closed as soon as the drain begins rather than
+// waited for like user requests: the health check fails, the registered
+// connections are closed, then the delays and the request drain run. A
+// connection registered once the drain began is closed straight away. Call
+// the returned function once c is closed on its own.
+func (s *Server) RegisterEagerClose(c net.Conn) (unregister func()) {
+	if !s.eager.add(c) {
+		_ = c.Close()
+		return func() {}
+	}
+
+	return func() { s.eager.remove(c) }
+}
+
+// EagerClose wraps h to register the connection of each request it serves
+// with RegisterEagerClose while the request runs, for handlers such as a
+// profiler endpoint that hold their connection open:
+//
+//	mux.Handle("/debug/fgprof", s.EagerClose(fgprof.Handler()))
+//
+// It needs the request to come from one of the http servers.
+func (s *Server) EagerClose(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			defer s.RegisterEagerClose(c)()
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rememberConns makes the connection of each request of server available to
+// EagerClose
+func (s *Server) rememberConns(server *http.Server) {
+	connContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+
+		return context.WithValue(ctx, connKey{}, c)
+	}
+}
+
+// closeEager closes the RegisterEagerClose connections as the drain begins
+func (s *Server) closeEager() {
+	n := s.eager.closeAll()
+	if n > 0 {
+		s.logger.Infof("Closed %d connections registered to close as the drain begins", n)
+	}
+}
@@ -0,0 +1,72 @@
+package graceful
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The environment variables read by FromEnv
+const (
+	envShutdownTimeout = "GRACEFUL_SHUTDOWN_TIMEOUT"
+	envDrainDelay      = "GRACEFUL_DRAIN_DELAY"
+	envPIDFile         = "GRACEFUL_PIDFILE"
+	envUpgrades        = "GRACEFUL_UPGRADES"
+)
+
+// FromEnv returns options for the settings in these environment variables,
+// leaving the defaults for those that are unset or empty:
+//
+//   - GRACEFUL_SHUTDOWN_TIMEOUT, a duration such as "20s", for WithShutdownTimeout
+//   - GRACEFUL_DRAIN_DELAY, a duration, for WithDrainDelay
+//   - GRACEFUL_PIDFILE, a path, for WithPIDFile
+//   - GRACEFUL_UPGRADES, a boolean such as "false", for WithUpgrades
+//
+// Serve fails for a malformed value. Options are applied in order, so
+// options given after FromEnv override the environment, and options given
+// before it are overridden by it:
+//
+//	s := graceful.New(append(graceful.FromEnv(), graceful.WithPIDFile(path))...)
+func FromEnv() []Option {
+	var opts []Option
+
+	if v := os.Getenv(envShutdownTimeout); v != "" {
+		opts = append(opts, envDuration(envShutdownTimeout, v, WithShutdownTimeout))
+	}
+	if v := os.Getenv(envDrainDelay); v != "" {
+		opts = append(opts, envDuration(envDrainDelay, v, WithDrainDelay))
+	}
+	if v := os.Getenv(envPIDFile); v != "" {
+		opts = append(opts, WithPIDFile(v))
+	}
+	if v := os.Getenv(envUpgrades); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			opts = append(opts, withOptionError(fmt.Errorf("graceful: invalid %s %q: %w", envUpgrades, v, err)))
+		} else {
+			opts = append(opts, WithUpgrades(enabled))
+		}
+	}
+
+	return opts
+}
+
+// envDuration returns the option opt for the duration v of the variable
+// name, or one failing Serve if v is malformed
+func envDuration(name, v string, opt func(time.Duration) Option) Option {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return withOptionError(fmt.Errorf("graceful: invalid %s %q: %w", name, v, err))
+	}
+
+	return opt(d)
+}
+
+// withOptionError returns an option that makes Serve and Validate fail with
+// err
+func withOptionError(err error) Option {
+	return func(s *Server) {
+		s.optionErrs = append(s.optionErrs, err)
+	}
+}
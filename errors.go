@@ -0,0 +1,162 @@
+package graceful
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Error categories returned by Serve and its variants, for use with errors.Is
+var (
+	// ErrBind is returned when a listener can't be created or adopted
+	ErrBind = errors.New("graceful: bind failed")
+	// ErrServe is returned when a server stops serving with an error
+	ErrServe = errors.New("graceful: serve failed")
+	// ErrDrainTimeout is returned when a server didn't drain in time
+	ErrDrainTimeout = errors.New("graceful: drain timed out")
+	// ErrUpgrade is returned when an upgrade or the handoff from the parent
+	// process fails
+	ErrUpgrade = errors.New("graceful: upgrade failed")
+)
+
+// Exit codes returned by RunMain and ExitCode for each error category, and
+// by the WithForceExitAfter watchdog
+const (
+	ExitOK           = 0
+	ExitFailure      = 1
+	ExitBind         = 3
+	ExitServe        = 4
+	ExitDrainTimeout = 5
+	ExitUpgrade      = 6
+	ExitWatchdog     = 7
+)
+
+// ExitCode maps an error returned by Serve to a process exit code. When err
+// holds several categories the one that caused the shutdown wins: bind,
+// upgrade, serve, then drain timeout.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrBind):
+		return ExitBind
+	case errors.Is(err, ErrUpgrade):
+		return ExitUpgrade
+	case errors.Is(err, ErrServe):
+		return ExitServe
+	case errors.Is(err, ErrDrainTimeout):
+		return ExitDrainTimeout
+	}
+
+	return ExitFailure
+}
+
+// Severity is how an actor's error is handled, see AddClassified
+type Severity int
+
+const (
+	// SeverityFatal shuts the process down and returns the error from Serve
+	SeverityFatal Severity = iota
+	// SeverityError logs the error and keeps the other actors running
+	SeverityError
+	// SeverityIgnore drops the error and keeps the other actors running
+	SeverityIgnore
+)
+
+// DefaultClassifier is the classification used by the server actors: a
+// closed server is ignored and any other error is fatal
+func DefaultClassifier(err error) Severity {
+	if errors.Is(err, http.ErrServerClosed) {
+		return SeverityIgnore
+	}
+
+	return SeverityFatal
+}
+
+// kindError tags an error with one of the error categories
+type kindError struct {
+	kind error
+	err  error
+}
+
+// withKind tags err with kind, keeping its message and chain
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// multiError is a list of errors raised during one lifecycle, in the order
+// they occurred. It supports errors.Is and errors.As on each error.
+type multiError []error
+
+// joinErrors combines errs, dropping nils. It returns nil when there are no
+// errors and the error itself when there is only one.
+func joinErrors(errs ...error) error {
+	var joined multiError
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if m, ok := err.(multiError); ok {
+			joined = append(joined, m...)
+			continue
+		}
+
+		joined = append(joined, err)
+	}
+
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	}
+
+	return joined
+}
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual errors
+func (m multiError) Unwrap() []error {
+	return m
+}
+
+func (m multiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m multiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
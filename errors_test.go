@@ -0,0 +1,123 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, ExitOK},
+		{"uncategorised", failed, ExitFailure},
+		{"bind", withKind(ErrBind, failed), ExitBind},
+		{"serve", withKind(ErrServe, failed), ExitServe},
+		{"drain timeout", withKind(ErrDrainTimeout, failed), ExitDrainTimeout},
+		{"upgrade", withKind(ErrUpgrade, failed), ExitUpgrade},
+		{"wrapped", fmt.Errorf("serving: %w", withKind(ErrServe, failed)), ExitServe},
+		{"serve before drain timeout", joinErrors(withKind(ErrDrainTimeout, failed), withKind(ErrServe, failed)), ExitServe},
+		{"upgrade before serve", joinErrors(withKind(ErrServe, failed), withKind(ErrUpgrade, failed)), ExitUpgrade},
+		{"bind before upgrade", joinErrors(withKind(ErrUpgrade, failed), withKind(ErrBind, failed)), ExitBind},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// failingListener is a listener whose Accept fails for good
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func TestServeError(t *testing.T) {
+	failed := errors.New("accept failed")
+	s := newTestServer(t)
+	ln := failingListener{Listener: listen(t), err: failed}
+	defer ln.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, &http.Server{Handler: http.NotFoundHandler()}) }()
+
+	err := waitErr(t, errc, "Serve")
+	if !errors.Is(err, ErrServe) || !errors.Is(err, failed) {
+		t.Errorf("Serve returned %v, want ErrServe wrapping %v", err, failed)
+	}
+	if code := ExitCode(err); code != ExitServe {
+		t.Errorf("ExitCode() = %d, want %d", code, ExitServe)
+	}
+}
+
+func TestDrainTimeoutError(t *testing.T) {
+	s := newTestServer(t, WithShutdownTimeout(50*time.Millisecond))
+	release := make(chan struct{})
+	defer close(release)
+
+	errc, _ := servePending(t, s, release)
+	go func() { _ = s.Shutdown(context.Background()) }()
+
+	err := waitErr(t, errc, "Serve")
+	if !errors.Is(err, ErrDrainTimeout) {
+		t.Errorf("Serve returned %v, want ErrDrainTimeout", err)
+	}
+	if code := ExitCode(err); code != ExitDrainTimeout {
+		t.Errorf("ExitCode() = %d, want %d", code, ExitDrainTimeout)
+	}
+}
+
+func TestUpgraderError(t *testing.T) {
+	failed := errors.New("no upgrader")
+	s := newTestServer(t,
+		WithUpgrades(true),
+		WithUpgrader(func(UpgraderOptions) (Upgrader, error) {
+			return nil, failed
+		}),
+	)
+	ln := listen(t)
+	defer ln.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, &http.Server{Handler: http.NotFoundHandler()}) }()
+
+	err := waitErr(t, errc, "Serve")
+	if !errors.Is(err, ErrUpgrade) || !errors.Is(err, failed) {
+		t.Errorf("Serve returned %v, want ErrUpgrade wrapping %v", err, failed)
+	}
+	if code := ExitCode(err); code != ExitUpgrade {
+		t.Errorf("ExitCode() = %d, want %d", code, ExitUpgrade)
+	}
+}
+
+func TestFailedUpgradeError(t *testing.T) {
+	u := newFakeUpgrader()
+	u.err = errors.New("child failed to start")
+	s, errc := serveFake(t, u)
+	defer func() {
+		_ = s.Shutdown(context.Background())
+		waitServe(t, errc)
+	}()
+
+	err := s.upgrade()
+	if !errors.Is(err, ErrUpgrade) || !errors.Is(err, u.err) {
+		t.Errorf("upgrade() = %v, want ErrUpgrade wrapping %v", err, u.err)
+	}
+}
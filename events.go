@@ -0,0 +1,124 @@
+package graceful
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType identifies a lifecycle event
+type EventType int
+
+// Lifecycle events
+const (
+	// EventListening is emitted for each server once its listener is bound
+	EventListening EventType = iota + 1
+	// EventUpgradeStarted is emitted when an upgrade is triggered
+	EventUpgradeStarted
+	// EventUpgradeComplete is emitted when the new process has taken over
+	EventUpgradeComplete
+	// EventUpgradeFailed is emitted when an upgrade fails and this process keeps serving
+	EventUpgradeFailed
+	// EventShutdownStarted is emitted when graceful shutdown begins
+	EventShutdownStarted
+	// EventShutdownComplete is emitted when all servers have been shut down
+	EventShutdownComplete
+	// EventError is emitted for errors that don't stop the lifecycle
+	EventError
+)
+
+var eventNames = map[EventType]string{
+	EventListening:        "listening",
+	EventUpgradeStarted:   "upgrade_started",
+	EventUpgradeComplete:  "upgrade_complete",
+	EventUpgradeFailed:    "upgrade_failed",
+	EventShutdownStarted:  "shutdown_started",
+	EventShutdownComplete: "shutdown_complete",
+	EventError:            "error",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventNames[t]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// Event describes a lifecycle transition
+type Event struct {
+	Type EventType
+	Time time.Time
+	PID  int
+
+	// Addr is the server address the event relates to, if any
+	Addr string
+	// Duration is the time the operation took, if any
+	Duration time.Duration
+	// Err is the error that caused the event, if any
+	Err error
+	// ForceClosed is the number of connections closed because they outlived the shutdown timeout
+	ForceClosed int
+	// Conns is the number of open connections when a shutdown started
+	Conns int
+	// Upgrades is the number of upgrades this process has completed
+	Upgrades int
+}
+
+// emit sends ev to the configured event handlers
+func (s *Server) emit(ev Event) {
+	if len(s.eventHandlers) == 0 {
+		return
+	}
+
+	ev.Time = s.clock.Now()
+	ev.PID = os.Getpid()
+
+	for _, handler := range s.eventHandlers {
+		handler(ev)
+	}
+}
+
+// jsonEvent is the JSON form of an Event
+type jsonEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	PID         int       `json:"pid"`
+	Addr        string    `json:"addr,omitempty"`
+	Duration    float64   `json:"duration,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	ForceClosed int       `json:"force_closed,omitempty"`
+	Conns       int       `json:"conns,omitempty"`
+	Upgrades    int       `json:"upgrades,omitempty"`
+}
+
+// JSONEventWriter returns an event handler for WithEventHandler that writes
+// each event to w as a line of JSON, with the duration in seconds. It is
+// safe for concurrent use; write errors are dropped.
+func JSONEventWriter(w io.Writer) func(Event) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return func(ev Event) {
+		je := jsonEvent{
+			Type:        ev.Type.String(),
+			Time:        ev.Time,
+			PID:         ev.PID,
+			Addr:        ev.Addr,
+			Duration:    ev.Duration.Seconds(),
+			ForceClosed: ev.ForceClosed,
+			Conns:       ev.Conns,
+			Upgrades:    ev.Upgrades,
+		}
+		if ev.Err != nil {
+			je.Error = ev.Err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		_ = enc.Encode(je)
+	}
+}
@@ -0,0 +1,436 @@
+//go:build !windows
+// +build !windows
+
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// execEnv lists the listeners passed to a child of the exec upgrader, one
+// "network:addr" per line, in the order of their files from execFirstFd
+const execEnv = "GRACEFUL_EXEC_LISTENERS"
+
+// The files passed to a child: the pipe it signals readiness on, the pipe
+// that reaches EOF when the parent exits, then the listeners
+const (
+	execReadyFd = 3
+	execExitFd  = 4
+	execFirstFd = 5
+)
+
+// execListener is a listener registered with the exec upgrader
+type execListener struct {
+	network string
+	addr    string
+	ln      InheritableListener
+}
+
+// execUpgrader upgrades by starting the executable again with the listeners
+// in ExtraFiles
+type execUpgrader struct {
+	opts UpgraderOptions
+
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	used      []execListener
+	upgrading bool
+	ready     bool
+	upgraded  bool
+
+	readyW       *os.File
+	parentExited chan struct{}
+	// exitW is the write end of the child's exit pipe. It is never closed,
+	// so the child sees EOF only once this process has exited.
+	exitW *os.File
+
+	stopOnce sync.Once
+	stopC    chan struct{}
+	exitOnce sync.Once
+	exitC    chan struct{}
+}
+
+// ExecUpgrader creates an upgrader that starts the executable again with the
+// same arguments and passes the listeners as inherited files, without
+// tableflip. Only listeners are inherited.
+func ExecUpgrader(opts UpgraderOptions) (Upgrader, error) {
+	if opts.UpgradeTimeout <= 0 {
+		opts.UpgradeTimeout = time.Minute
+	}
+
+	u := &execUpgrader{
+		opts:      opts,
+		inherited: make(map[string]*os.File),
+		stopC:     make(chan struct{}),
+		exitC:     make(chan struct{}),
+	}
+
+	names, ok := os.LookupEnv(execEnv)
+	if !ok {
+		return u, nil
+	}
+
+	// Don't pass the listeners on to unrelated children
+	_ = os.Unsetenv(execEnv)
+
+	if names != "" {
+		for i, name := range strings.Split(names, "\n") {
+			syscall.CloseOnExec(execFirstFd + i)
+			u.inherited[name] = os.NewFile(uintptr(execFirstFd+i), name)
+		}
+	}
+	syscall.CloseOnExec(execReadyFd)
+	syscall.CloseOnExec(execExitFd)
+
+	u.readyW = os.NewFile(execReadyFd, "ready")
+	u.parentExited = make(chan struct{})
+
+	exitR := os.NewFile(execExitFd, "exit")
+	go func() {
+		defer close(u.parentExited)
+		defer exitR.Close()
+
+		_, _ = io.Copy(ioutil.Discard, exitR)
+	}()
+
+	return u, nil
+}
+
+func (u *execUpgrader) ListenWithCallback(network, addr string, callback func(network, addr string) (net.Listener, error)) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	name := network + ":" + addr
+	if f, ok := u.inherited[name]; ok {
+		delete(u.inherited, name)
+
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inheriting %s: %w", name, err)
+		}
+
+		return ln, u.addLocked(network, addr, ln)
+	}
+
+	ln, err := callback(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.addLocked(network, addr, ln)
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+func (u *execUpgrader) AddListener(network, addr string, ln InheritableListener) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.addLocked(network, addr, ln)
+}
+
+func (u *execUpgrader) addLocked(network, addr string, ln net.Listener) error {
+	il, ok := ln.(InheritableListener)
+	if !ok {
+		return fmt.Errorf("%T can't be inherited", ln)
+	}
+	if strings.Contains(addr, "\n") {
+		return fmt.Errorf("address %q can't be inherited", addr)
+	}
+
+	// A listener added again for an address replaces the old one
+	l := execListener{network: network, addr: addr, ln: il}
+	for i, used := range u.used {
+		if used.network == network && used.addr == addr {
+			u.used[i] = l
+			return nil
+		}
+	}
+
+	u.used = append(u.used, l)
+	return nil
+}
+
+func (u *execUpgrader) Ready() error {
+	u.mu.Lock()
+	u.ready = true
+	for name, f := range u.inherited {
+		_ = f.Close()
+		delete(u.inherited, name)
+	}
+	readyW := u.readyW
+	u.readyW = nil
+	u.mu.Unlock()
+
+	if u.opts.PIDFile != "" {
+		err := writePIDFile(u.opts.PIDFile)
+		if err != nil {
+			return fmt.Errorf("writing PID file: %w", err)
+		}
+	}
+
+	if readyW == nil {
+		return nil
+	}
+	defer readyW.Close()
+
+	_, err := readyW.Write([]byte{1})
+	if err != nil {
+		return fmt.Errorf("notifying the parent process: %w", err)
+	}
+
+	return nil
+}
+
+func (u *execUpgrader) Exit() <-chan struct{} {
+	return u.exitC
+}
+
+func (u *execUpgrader) Upgrade() error {
+	u.mu.Lock()
+	switch {
+	case u.upgraded:
+		u.mu.Unlock()
+		return errors.New("already upgraded")
+	case u.upgrading:
+		u.mu.Unlock()
+		return errors.New("upgrade in progress")
+	case !u.ready:
+		u.mu.Unlock()
+		return errors.New("process is not ready yet")
+	}
+	if u.parentExited != nil {
+		select {
+		case <-u.parentExited:
+		default:
+			u.mu.Unlock()
+			return errors.New("parent hasn't exited")
+		}
+	}
+	u.upgrading = true
+	used := append([]execListener(nil), u.used...)
+	u.mu.Unlock()
+
+	exitW, err := u.startChild(used)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.upgrading = false
+	if err != nil {
+		return err
+	}
+
+	u.upgraded = true
+	u.exitW = exitW
+	u.exitOnce.Do(func() { close(u.exitC) })
+
+	return nil
+}
+
+// startChild starts the executable with the used listeners and waits for it
+// to be ready. It returns the write end of the child's exit pipe.
+func (u *execUpgrader) startChild(used []execListener) (*os.File, error) {
+	select {
+	case <-u.stopC:
+		return nil, errors.New("terminating")
+	default:
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("finding executable: %w", err)
+	}
+
+	var (
+		names []string
+		files []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	exitR, exitW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, exitR)
+
+	for _, l := range used {
+		f, err := dupFile(l.ln, l.network+":"+l.addr)
+		if err != nil {
+			_ = exitW.Close()
+			return nil, fmt.Errorf("duplicating %s: %w", l.addr, err)
+		}
+
+		names = append(names, l.network+":"+l.addr)
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), execEnv+"="+strings.Join(names, "\n"))
+	cmd.ExtraFiles = files
+
+	err = cmd.Start()
+	if err != nil {
+		_ = exitW.Close()
+		return nil, fmt.Errorf("can't start child: %w", err)
+	}
+
+	// Only the child holds these now, so reads see EOF if it exits
+	for _, f := range files {
+		_ = f.Close()
+	}
+	files = nil
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		n, err := readyR.Read(b)
+		if n == 0 && err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		ready <- err
+	}()
+
+	timer := time.NewTimer(u.opts.UpgradeTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-ready:
+		if err == nil {
+			go func() { _ = cmd.Wait() }()
+			return exitW, nil
+		}
+		err = fmt.Errorf("child %d exited before it was ready: %w", cmd.Process.Pid, err)
+		_ = cmd.Wait()
+		_ = exitW.Close()
+		return nil, err
+
+	case <-timer.C:
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = exitW.Close()
+		return nil, fmt.Errorf("new child %d timed out", cmd.Process.Pid)
+
+	case <-u.stopC:
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = exitW.Close()
+		return nil, errors.New("terminating")
+	}
+}
+
+func (u *execUpgrader) Stop() {
+	u.stopOnce.Do(func() {
+		close(u.stopC)
+
+		u.mu.Lock()
+		defer u.mu.Unlock()
+
+		// The socket files outlive this process only if a child uses
+		// them, which it may once an upgrade is under way
+		if !u.upgraded && !u.upgrading {
+			for _, l := range u.used {
+				if l.network == "unix" {
+					_ = os.Remove(l.addr)
+				}
+			}
+		}
+
+		u.exitOnce.Do(func() { close(u.exitC) })
+	})
+}
+
+func (u *execUpgrader) HasParent() bool {
+	return u.parentExited != nil
+}
+
+func (u *execUpgrader) WaitForParent(ctx context.Context) error {
+	if u.parentExited == nil {
+		return nil
+	}
+
+	select {
+	case <-u.parentExited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dupFile returns a duplicate of the file descriptor of conn
+func dupFile(conn syscall.Conn, name string) (*os.File, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		dup    int
+		duperr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		dup, duperr = syscall.Dup(int(fd))
+		if duperr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if duperr != nil {
+		return nil, duperr
+	}
+
+	return os.NewFile(uintptr(dup), name), nil
+}
+
+// writePIDFile atomically replaces path with the process ID
+func writePIDFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package graceful
+
+import "errors"
+
+// ExecUpgrader creates an upgrader that starts the executable again with the
+// listeners as inherited files. Windows can't inherit sockets this way, so it
+// always fails there.
+func ExecUpgrader(opts UpgraderOptions) (Upgrader, error) {
+	return nil, errors.New("graceful: the exec upgrader is not supported on windows")
+}
@@ -0,0 +1,70 @@
+// Package expvargraceful publishes the lifecycle counters of graceful servers
+// through expvar, for services that expose /debug/vars but not Prometheus. It
+// is a separate package so importing graceful doesn't register expvar's
+// handler on http.DefaultServeMux.
+package expvargraceful
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/codechimp-io/graceful"
+)
+
+// The counters are shared by every Server in the process, since expvar names
+// are global
+var (
+	publishOnce sync.Once
+	upgrades    = new(expvar.Int)
+	shutdowns   = new(expvar.Int)
+	connections = new(expvar.Int)
+	lastDrain   = new(expvar.Float)
+)
+
+// WithExpvar publishes the upgrades completed, shutdowns, open connections
+// and last drain duration under "graceful" in the expvar map. They are
+// updated from the event and connection state hooks, and summed over every
+// Server in the process that enables them.
+func WithExpvar(enabled bool) graceful.Option {
+	return func(s *graceful.Server) {
+		if !enabled {
+			return
+		}
+
+		publish()
+		graceful.WithEventHandler(onEvent)(s)
+		graceful.WithConnStateHook(onConnState)(s)
+	}
+}
+
+// publish adds the counters to the expvar map the first time it is called
+func publish() {
+	publishOnce.Do(func() {
+		m := expvar.NewMap("graceful")
+		m.Set("upgrades", upgrades)
+		m.Set("shutdowns", shutdowns)
+		m.Set("connections", connections)
+		m.Set("last_drain_seconds", lastDrain)
+	})
+}
+
+func onEvent(e graceful.Event) {
+	switch e.Type {
+	case graceful.EventUpgradeComplete:
+		upgrades.Add(1)
+	case graceful.EventShutdownComplete:
+		shutdowns.Add(1)
+		lastDrain.Set(e.Duration.Seconds())
+	}
+}
+
+func onConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		connections.Add(-1)
+	}
+}
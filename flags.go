@@ -0,0 +1,75 @@
+package graceful
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+)
+
+// The timeouts of the server Flags.Main builds. The idle timeout closes keep-alive
+// connections well within a drain, and the read timeouts bound slow clients.
+// There is no write timeout, so streaming responses aren't cut off.
+const (
+	mainReadHeaderTimeout = 10 * time.Second
+	mainReadTimeout       = 30 * time.Second
+	mainIdleTimeout       = 60 * time.Second
+)
+
+// Flags are the settings of the command line flags defined by RegisterFlags
+type Flags struct {
+	Addr            string
+	PIDFile         string
+	ShutdownTimeout time.Duration
+}
+
+// RegisterFlags defines these flags on fs, to be set once fs is parsed:
+//
+//	-addr string                 address to listen on (default ":8080")
+//	-pidfile string              PID file used for upgrades (default none)
+//	-shutdown-timeout duration   how long to drain connections (default ShutdownTimeout)
+//
+// A program with flags of its own registers these on the same flag set,
+// usually flag.CommandLine, parses it and calls Flags.Main.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Addr, "addr", ":8080", "address to listen on")
+	fs.StringVar(&f.PIDFile, "pidfile", "", "PID file used for upgrades")
+	fs.DurationVar(&f.ShutdownTimeout, "shutdown-timeout", ShutdownTimeout, "how long to drain connections")
+
+	return f
+}
+
+// Main serves handler as configured by the flags of RegisterFlags, parsed
+// from os.Args on a flag set of its own, and returns the exit code for how it
+// stopped, so main can be os.Exit(graceful.Main(handler)). It leaves
+// flag.CommandLine alone; a program defining flags of its own uses
+// RegisterFlags and Flags.Main instead. opts are applied after the flags.
+func Main(handler http.Handler, opts ...Option) int {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	f := RegisterFlags(fs)
+	_ = fs.Parse(os.Args[1:])
+
+	return f.Main(handler, opts...)
+}
+
+// Main serves handler as configured by f, applying opts after the flags, and
+// returns the exit code for how it stopped
+func (f *Flags) Main(handler http.Handler, opts ...Option) int {
+	server := &http.Server{
+		Addr:              f.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: mainReadHeaderTimeout,
+		ReadTimeout:       mainReadTimeout,
+		IdleTimeout:       mainIdleTimeout,
+	}
+
+	s := New(append([]Option{WithPIDFile(f.PIDFile), WithShutdownTimeout(f.ShutdownTimeout)}, opts...)...)
+
+	err := s.Serve(server)
+	if err != nil {
+		s.logger.Errorf("Service stopped: %s", err)
+	}
+
+	return ExitCode(err)
+}
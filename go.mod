@@ -6,4 +6,6 @@ require (
 	github.com/cloudflare/tableflip v1.2.3
 	github.com/codechimp-io/log v1.1.10
 	github.com/oklog/run v1.1.0
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
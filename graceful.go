@@ -2,135 +2,1052 @@ package graceful
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudflare/tableflip"
-	"github.com/codechimp-io/log"
-	"github.com/oklog/run"
+	"golang.org/x/net/http2"
 )
 
+// ShutdownTimeout is the default shutdown timeout for servers created with New.
+// It is read once by New; use WithShutdownTimeout instead of changing it while
+// servers are being created.
 var ShutdownTimeout = 3 * time.Second
 
+// Server manages the lifecycle of a graceful http server
+type Server struct {
+	// drainStarted is accessed atomically and first for 64-bit alignment
+	drainStarted int64
+
+	pidfile               string
+	lockWait              time.Duration
+	pidFileMode           os.FileMode
+	pidDirMode            os.FileMode
+	shutdownTimeout       time.Duration
+	drainDelay            time.Duration
+	probeDelay            time.Duration
+	lameDuck              time.Duration
+	shutdownDeadline      time.Duration
+	forceExitAfter        time.Duration
+	shutdownReserve       time.Duration
+	hijackTimeout         time.Duration
+	upgradeLinger         time.Duration
+	upgradeEnv            func() []string
+	shutdownParent        context.Context
+	upgradeTimeout        time.Duration
+	upgradeDrainTimeout   time.Duration
+	newUpgrader           func(UpgraderOptions) (Upgrader, error)
+	minUpgradeInterval    time.Duration
+	bindRetries           int
+	bindBackoff           time.Duration
+	tcpKeepAlive          *bool
+	tcpKeepAlivePeriod    time.Duration
+	proxyProtocol         bool
+	listenerReload        bool
+	relistenMu            sync.Mutex
+	pauseMu               sync.Mutex
+	resumeC               chan struct{}
+	childSignaling        bool
+	requireHandler        bool
+	acceptLimit           int
+	refuseWhilePaused     bool
+	listenerWrappers      []func(net.Listener) net.Listener
+	listenerName          string
+	network               string
+	listenFunc            func(network, addr string) (net.Listener, error)
+	listenConfig          net.ListenConfig
+	rebind                bool
+	reusePort             bool
+	timeouts              serverTimeouts
+	idleTimeout           time.Duration
+	selfCheck             func() error
+	selfCheckInterval     time.Duration
+	selfCheckFailures     int
+	memoryPressure        float64
+	pressurePath          func() string
+	disableKeepAlives     bool
+	forceClose            bool
+	logger                Logger
+	clock                 clock
+	startupLog            func(addr net.Addr, pid int)
+	certFile              string
+	keyFile               string
+	certs                 *certReloader
+	h2s                   *http2.Server
+	healthPath            string
+	drainDiagnostics      bool
+	virtualHosts          []virtualHost
+	handler               atomic.Value
+	eager                 eagerTracker
+	maxRequests           int
+	reloadFile            string
+	startupTimeout        time.Duration
+	drainStatus           int
+	drainRetryAfter       time.Duration
+	maintenancePage       []byte
+	drainExemptPaths      []string
+	drainProgress         func(remaining int)
+	drainProgressInterval time.Duration
+	countdownInterval     time.Duration
+	shutdownSignals       []os.Signal
+	upgradeSignals        []os.Signal
+	immediateSignals      []os.Signal
+	signalActions         map[os.Signal]Action
+	systemdNotify         bool
+	sd                    *sdNotifier
+	fdSetup               []func(*tableflip.Upgrader) error
+	onReady               []func()
+	postUpgrade           []func()
+	handoffComplete       []func()
+	beforeExit            []func()
+	eventHandlers         []func(Event)
+	connStateHooks        []func(net.Conn, http.ConnState)
+	upgradeErrorHandlers  []func(error)
+	upgradePreconditions  []func() error
+	signalHandlers        []signalHandler
+	upgrades              bool
+
+	// notify and stopNotify default to signal.Notify and signal.Stop and
+	// are only replaced by tests. shutdownCh has room for the signal that
+	// starts the drain and the same one again forcing it, however quickly
+	// they arrive; upgrade signals received during an upgrade are coalesced.
+	notify     func(chan<- os.Signal, ...os.Signal)
+	stopNotify func(chan<- os.Signal)
+	shutdownCh chan os.Signal
+	upgradeCh  chan os.Signal
+	handlerCh  chan os.Signal
+
+	shutdownOnce sync.Once
+	shutdownC    chan struct{}
+	ready        chan struct{}
+	doneOnce     sync.Once
+	done         chan struct{}
+	err          error
+
+	servers      []*managed
+	preShutdown  []func(context.Context) error
+	postShutdown []func(context.Context) error
+	drainOnce    sync.Once
+	forceOnce    sync.Once
+	forceC       chan struct{}
+
+	actors         []*actor
+	upg            Upgrader
+	upgStopOnce    sync.Once
+	served         int32
+	upgStopped     int32
+	handedOff      int32
+	upgrading      int32
+	upgradeMu      sync.Mutex
+	stopping       bool
+	baseCtx        context.Context
+	contextValues  func(net.Listener) context.Context
+	startCtx       context.Context
+	cancelBase     context.CancelFunc
+	registered     []registration
+	provided       map[*http.Server]net.Listener
+	serverTimeouts map[*http.Server]time.Duration
+	addrs          []string
+	activated      []*activatedListener
+	healthy        int32
+	draining       int32
+	state          int32
+	drainingC      chan struct{}
+
+	mu              sync.Mutex
+	drainErr        error
+	shutdownCause   ShutdownCause
+	shutdownSignal  os.Signal
+	children        map[int]struct{}
+	boundAddrs      []net.Addr
+	upgradeCount    int
+	lastUpgrade     time.Time
+	lastShutdown    time.Duration
+	workerErrs      []error
+	errGroupWorkers bool
+	optionErrs      []error
+	runReport       RunReport
+	// published is the snapshot of what serve set up
+	published atomic.Value
+}
+
+// New creates a Server configured with the given options
+func New(opts ...Option) *Server {
+	s := &Server{
+		shutdownTimeout:   ShutdownTimeout,
+		newUpgrader:       TableflipUpgrader,
+		countdownInterval: 5 * time.Second,
+		selfCheckFailures: 3,
+		clock:             realClock{},
+		pressurePath:      memoryPressurePath,
+		logger:            defaultLogger{},
+		shutdownSignals:   defaultShutdownSignals,
+		upgradeSignals:    defaultUpgradeSignals,
+		systemdNotify:     true,
+		upgrades:          upgradesSupported,
+		disableKeepAlives: true,
+		forceClose:        true,
+		network:           "tcp",
+		notify:            signal.Notify,
+		stopNotify:        signal.Stop,
+		shutdownCh:        make(chan os.Signal, 2),
+		upgradeCh:         make(chan os.Signal, 1),
+		handlerCh:         make(chan os.Signal, 1),
+		shutdownC:         make(chan struct{}),
+		forceC:            make(chan struct{}),
+		drainingC:         make(chan struct{}),
+		ready:             make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// Take the variables of an upgrade out before they reach any children
+	loadUpgradeEnv()
+
+	return s
+}
+
 // Run graceful http server
 func Run(server *http.Server, pidfile string) {
-	// configure graceful restart
-	upg, err := tableflip.New(tableflip.Options{
-		PIDFile: pidfile,
-	})
+	s := New(WithPIDFile(pidfile))
+
+	err := s.Serve(server)
 	if err != nil {
-		log.Errorf("Creating graceful upgrader failed: %v", err)
+		s.logger.Fatalf("Error starting service: %s", err)
+	}
+}
+
+// RunMain runs graceful http server and returns the exit code for how it
+// stopped, for main to pass to os.Exit
+func RunMain(server *http.Server, pidfile string) int {
+	s := New(WithPIDFile(pidfile))
+
+	err := s.Serve(server)
+	if err != nil {
+		s.logger.Errorf("Service stopped: %s", err)
+	}
+
+	return ExitCode(err)
+}
+
+// errAlreadyServed is returned when a Server is served more than once
+var errAlreadyServed = errors.New("graceful: Serve called more than once on the same Server")
+
+// RunE runs graceful http server and returns the error instead of exiting
+func RunE(server *http.Server, pidfile string) error {
+	return New(WithPIDFile(pidfile)).Serve(server)
+}
+
+// Options are per-call settings for RunWithOptions
+type Options struct {
+	// ShutdownTimeout overrides the package ShutdownTimeout when non-zero
+	ShutdownTimeout time.Duration
+}
+
+// RunWithOptions runs graceful http server with per-call settings. They are
+// captured once at start, so concurrent servers don't share mutable state.
+func RunWithOptions(server *http.Server, pidfile string, opts Options) error {
+	options := []Option{WithPIDFile(pidfile)}
+	if opts.ShutdownTimeout != 0 {
+		options = append(options, WithShutdownTimeout(opts.ShutdownTimeout))
+	}
+
+	return New(options...).Serve(server)
+}
+
+// RunMulti runs several graceful http servers under one upgrader
+func RunMulti(servers []*http.Server, pidfile string) {
+	s := New(WithPIDFile(pidfile))
+
+	err := s.Serve(servers...)
+	if err != nil {
+		s.logger.Fatalf("Error starting service: %s", err)
+	}
+}
+
+// RunContext runs graceful http server until it is shut down or ctx is done
+func RunContext(ctx context.Context, server *http.Server, pidfile string) error {
+	return New(WithPIDFile(pidfile)).ServeContext(ctx, server)
+}
+
+// ManagedServer is an http server with its own shutdown timeout
+type ManagedServer struct {
+	Server *http.Server
+	// ShutdownTimeout overrides the shutdown timeout for Server when non-zero
+	ShutdownTimeout time.Duration
+}
+
+// RunManaged runs several graceful http servers under one upgrader, each
+// draining concurrently within its own shutdown timeout
+func RunManaged(servers []ManagedServer, pidfile string) error {
+	return New(WithPIDFile(pidfile)).ServeManaged(servers...)
+}
+
+// ServeListener runs graceful http server on a listener owned by the caller
+func ServeListener(ln net.Listener, server *http.Server, pidfile string) error {
+	return New(WithPIDFile(pidfile)).ServeListener(ln, server)
+}
+
+// ServeListener runs the http server on ln instead of binding server.Addr.
+// The listener is not inherited across upgrades, but shutdown is still graceful.
+func (s *Server) ServeListener(ln net.Listener, server *http.Server) error {
+	if s.provided == nil {
+		s.provided = make(map[*http.Server]net.Listener)
+	}
+	s.provided[server] = ln
+
+	return s.Serve(server)
+}
+
+// ServeManaged runs the servers like Serve, shutting each down within its own
+// shutdown timeout or the one set by WithShutdownTimeout if it has none
+func (s *Server) ServeManaged(servers ...ManagedServer) error {
+	if s.serverTimeouts == nil {
+		s.serverTimeouts = make(map[*http.Server]time.Duration)
+	}
+
+	var srvs []*http.Server
+	for _, m := range servers {
+		if m.ShutdownTimeout > 0 {
+			s.serverTimeouts[m.Server] = m.ShutdownTimeout
+		}
+		srvs = append(srvs, m.Server)
+	}
+
+	return s.Serve(srvs...)
+}
+
+// Serve runs the http servers until they are shut down or upgraded.
+//
+// Hooks already set on the servers are kept and chained rather than replaced:
+// the package's ConnState tracking runs before the caller's ConnState, a
+// caller's BaseContext runs first and its context is additionally cancelled
+// on shutdown when WithBaseContext is used, and functions registered with
+// RegisterOnShutdown still run alongside the package's own.
+//
+// A Server is served once: calling Serve, its variants or Start again returns
+// an error. Only one Server per process can have upgrades enabled, as the
+// upgrader owns the files inherited by the process, and signals are delivered
+// to every Server that listens for them.
+func (s *Server) Serve(servers ...*http.Server) error {
+	return s.ServeContext(context.Background(), servers...)
+}
+
+// ServeContext runs the http servers until they are shut down, upgraded or
+// ctx is done. A context-driven shutdown returns an error wrapping ctx.Err().
+func (s *Server) ServeContext(ctx context.Context, servers ...*http.Server) error {
+	if !atomic.CompareAndSwapInt32(&s.served, 0, 1) {
+		return errAlreadyServed
+	}
+
+	return s.run(ctx, servers)
+}
+
+// run serves and records the outcome for Wait and Start
+func (s *Server) run(ctx context.Context, servers []*http.Server) error {
+	err := s.serve(ctx, servers)
+
+	s.mu.Lock()
+	err = joinErrors(append([]error{err, s.drainErr}, s.workerErrs...)...)
+	s.mu.Unlock()
+
+	s.setState(StateStopped)
+
+	// Serve runs once, so these do too, whichever way it returned
+	for _, fn := range s.beforeExit {
+		fn()
+	}
+
+	s.doneOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+
+	return err
+}
+
+// Addr returns the address the first listener is bound to, or nil before
+// Serve has bound it. Use it to find the port chosen for an address like ":0".
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.boundAddrs) == 0 {
+		return nil
+	}
+
+	return s.boundAddrs[0]
+}
+
+// Addrs returns the addresses of all bound listeners, in the order the servers
+// and their addresses were given
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]net.Addr(nil), s.boundAddrs...)
+}
+
+// Shutdown gracefully stops the running servers and waits for Serve to return
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdownC)
+	})
+
+	select {
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start serves the http servers in the background and returns once they are
+// listening and ready, or with the error that stopped them from starting.
+// Use Stop to shut them down.
+func (s *Server) Start(servers ...*http.Server) error {
+	if !atomic.CompareAndSwapInt32(&s.served, 0, 1) {
+		return errAlreadyServed
 	}
-	defer upg.Stop()
 
-	// Do an upgrade on SIGHUP
 	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGHUP)
-		for range sig {
-			log.Info("Received SIGHUP, restaring gracefully...")
-			err := upg.Upgrade()
+		_ = s.run(context.Background(), servers)
+	}()
+
+	select {
+	case <-s.ready:
+		return nil
+	case <-s.done:
+		return s.err
+	}
+}
+
+// Stop gracefully shuts down servers started with Start and returns the error
+// they stopped with, or ctx.Err() if ctx is done first
+func (s *Server) Stop(ctx context.Context) error {
+	return s.Shutdown(ctx)
+}
+
+// Wait blocks until Serve, or the servers run by Start, have returned and
+// returns the same error, at once if they already have. It can be called from
+// any number of goroutines, and blocks until the Server is served.
+func (s *Server) Wait() error {
+	<-s.done
+
+	return s.err
+}
+
+// actor is a caller-provided run.Group actor
+type actor struct {
+	execute   func() error
+	interrupt func(error)
+	priority  int
+	classify  func(error) Severity
+	// work is the function of a WithWorkers actor
+	work func(ctx context.Context) error
+	// name overrides the name in the RunReport
+	name string
+	// done is closed once execute has returned
+	done chan struct{}
+	// failed is an error execute reported without returning it
+	failed error
+}
+
+// Add registers an actor that runs alongside the servers. It must be called
+// before Serve. When any actor returns, all of them are interrupted, so a
+// failing worker shuts the whole process down gracefully.
+func (s *Server) Add(execute func() error, interrupt func(error)) {
+	s.AddWithPriority(0, execute, interrupt)
+}
+
+// AddWithPriority registers an actor like Add that is interrupted in the
+// shutdown phase for priority. Phases run in ascending order: the servers of a
+// phase are shut down concurrently, then its actors are interrupted, and the
+// next phase starts once their execute functions have returned. Servers passed
+// to Serve and Register have priority 0.
+func (s *Server) AddWithPriority(priority int, execute func() error, interrupt func(error)) {
+	s.actors = append(s.actors, &actor{execute: execute, interrupt: interrupt, priority: priority})
+}
+
+// AddClassified registers an actor like Add whose errors are passed to
+// classify. Only fatal errors shut the process down; after an error of any
+// other severity the actor stays stopped and the rest keep running until the
+// next shutdown. An actor that returns nil still shuts the process down.
+func (s *Server) AddClassified(execute func() error, interrupt func(error), classify func(error) Severity) {
+	s.actors = append(s.actors, &actor{execute: execute, interrupt: interrupt, classify: classify})
+}
+
+func (s *Server) serve(ctx context.Context, servers []*http.Server) error {
+	err := joinErrors(s.optionErrs...)
+	if err != nil {
+		return err
+	}
+
+	err = s.resolveSignalActions()
+	if err != nil {
+		return err
+	}
+
+	err = validateSignals(s.shutdownSignals, s.upgradeSignals, s.signalHandlers)
+	if err != nil {
+		return err
+	}
+
+	// Bound the time until the servers are ready
+	s.startCtx = context.Background()
+	if s.startupTimeout > 0 {
+		var cancel context.CancelFunc
+		s.startCtx, cancel = withTimeout(s.startCtx, s.clock, s.startupTimeout)
+		defer cancel()
+	}
+
+	if s.baseCtx != nil {
+		s.baseCtx, s.cancelBase = context.WithCancel(s.baseCtx)
+		defer s.cancelBase()
+	}
+
+	err = validateNetwork(s.network)
+	if err != nil {
+		return err
+	}
+
+	if len(s.addrs) > 0 && len(servers) != 1 {
+		return errors.New("graceful: WithAddrs requires exactly one http server")
+	}
+
+	for _, server := range servers {
+		addrs := []string{server.Addr}
+		if len(s.addrs) > 0 {
+			addrs = s.addrs
+		}
+
+		if s.contextValues != nil && server.BaseContext == nil {
+			server.BaseContext = s.contextValues
+		}
+		if s.baseCtx != nil {
+			server.BaseContext = s.chainBaseContext(server.BaseContext)
+		}
+
+		s.timeouts.apply(server)
+
+		m := newManaged(addrs, server, s.clock, s.connStateHooks)
+		m.shutdownTimeout = s.serverTimeouts[server]
+
+		s.servers = append(s.servers, m)
+	}
+	for _, r := range s.registered {
+		m := newManaged([]string{r.addr}, r.srv, s.clock, s.connStateHooks)
+		m.priority = r.priority
+
+		s.servers = append(s.servers, m)
+	}
+
+	for _, m := range s.servers {
+		if _, ok := s.provided[m.http]; ok && m.http != nil {
+			continue
+		}
+
+		for _, addr := range m.addrs {
+			err := validateAddr(addr, s.network)
 			if err != nil {
-				log.Errorf("Upgrade failed: %v", err)
+				return err
 			}
 		}
-	}()
+	}
 
-	var group run.Group
+	for _, m := range s.servers {
+		err := s.checkHandler(m.http)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Set up http server
-	{
-		ln, err := upg.Fds.Listen("tcp", server.Addr)
+	if s.certFile != "" || s.keyFile != "" {
+		certs, err := newCertReloader(s.certFile, s.keyFile)
 		if err != nil {
-			log.Fatalf("Error creating new listener: %s", err)
+			return fmt.Errorf("loading TLS certificate: %w", err)
 		}
+		s.certs = certs
+
+		for _, server := range servers {
+			server.TLSConfig = certs.tlsConfig(server.TLSConfig)
+		}
+	}
+
+	// Wrap the handlers innermost first: the SetHandler swap, virtual hosts,
+	// drain diagnostics, drain response, request limit, then the health check
+	for _, m := range s.servers {
+		if m.http != nil {
+			s.serveSwappable(m.http)
+			s.rememberConns(m.http)
+		}
+	}
+
+	if len(s.virtualHosts) > 0 {
+		for _, m := range s.servers {
+			if m.http != nil {
+				s.serveVirtualHosts(m.http)
+			}
+		}
+	}
+
+	if s.drainDiagnostics {
+		for _, m := range s.servers {
+			if m.http != nil {
+				s.trackRequests(m)
+			}
+		}
+	}
+
+	if s.drainStatus != 0 {
+		for _, m := range s.servers {
+			if m.http != nil {
+				s.rejectDuringDrain(m.http)
+			}
+		}
+	}
+
+	if s.maxRequests > 0 {
+		for _, m := range s.servers {
+			if m.http != nil {
+				s.limitRequests(m.http, s.maxRequests)
+			}
+		}
+	}
+
+	if s.healthPath != "" {
+		for _, m := range s.servers {
+			if m.http != nil {
+				s.serveHealth(m.http)
+			}
+		}
+	}
+
+	if s.h2s != nil && s.certs == nil {
+		for _, m := range s.servers {
+			if m.http == nil {
+				continue
+			}
+
+			err := s.enableH2C(m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.systemdNotify {
+		s.sd = newSDNotifier()
+	}
+
+	if s.upgrades && !upgradesSupported {
+		s.logger.Warnf("Graceful upgrades are not supported on this platform, disabling them")
+		s.upgrades = false
+	}
+
+	s.publish()
+	s.warnInit()
+
+	// configure graceful restart
+	var upg Upgrader
+	if s.upgrades {
+		err = s.preparePIDFile()
+		if err != nil {
+			return err
+		}
+
+		upg, err = s.newUpgrader(UpgraderOptions{
+			PIDFile:        s.pidfile,
+			UpgradeTimeout: s.upgradeTimeout,
+		})
+		if err != nil {
+			return withKind(ErrUpgrade, fmt.Errorf("creating graceful upgrader: %w", err))
+		}
+	} else {
+		upg = newNoUpgrader()
+	}
+	s.upg = upg
+	defer s.stopUpgrader()
+	if upg.HasParent() {
+		atomic.StoreInt32(&upgraded, 1)
+	}
+	s.logger = processLogger(s.logger, upg.HasParent())
+
+	if s.upgrades && !upg.HasParent() {
+		err := s.checkRunningInstance()
+		if err != nil {
+			return err
+		}
+	}
+
+	group := runGroup{s: s}
+
+	// Do an upgrade on SIGHUP
+	if s.upgrades && len(s.upgradeSignals) > 0 {
+		var (
+			cancelUpgrade = make(chan struct{})
+			sig           = s.upgradeCh
+			lastUpgrade   time.Time
+		)
 
 		group.Add(
+			"upgrade signals",
 			func() error {
-				log.Infof("Listening on [%s] with pid [%d]", server.Addr, os.Getpid())
+				s.notify(sig, s.upgradeSignals...)
 
-				return server.Serve(ln)
-			},
-			func(e error) {
-				if e != nil {
-					log.Fatalf("Failed to start HTTP Server: %v", e)
-				}
+				for {
+					select {
+					case received := <-sig:
+						// Drop signals that arrive too soon after the last upgrade
+						if elapsed := since(s.clock, lastUpgrade); !lastUpgrade.IsZero() && elapsed < s.minUpgradeInterval {
+							s.logger.Warnf("Received %s %s after the last upgrade, throttling it", signalName(received), elapsed.Round(time.Millisecond))
+							continue
+						}
+						lastUpgrade = s.clock.Now()
 
-				log.Info("Shutting HTTP Server down")
+						s.logger.Infof("Received %s, restarting gracefully...", signalName(received))
+						err := s.upgrade()
+						s.discardSignals(sig)
 
-				ctx := context.Background()
-				if ShutdownTimeout > 0 {
-					var cancel context.CancelFunc
-					ctx, cancel = context.WithTimeout(ctx, ShutdownTimeout)
-					defer cancel()
-				}
+						// The new process handles upgrades from now on
+						if err == nil {
+							<-cancelUpgrade
+							return nil
+						}
 
-				err := server.Shutdown(ctx)
-				if err != nil {
-					log.Errorf("Error shutting down HTTP server: %s", err)
+					case <-cancelUpgrade:
+						return nil
+					}
 				}
-
-				_ = server.Close()
+			},
+			func(e error) {
+				s.stopNotify(sig)
+				close(cancelUpgrade)
 			},
 		)
 	}
 
+	// Without upgrades, SIGHUP still picks up a renewed certificate
+	if !s.upgrades && s.certs != nil && len(s.upgradeSignals) > 0 {
+		s.addCertReload(&group)
+	}
+
+	if s.upgrades && s.reloadFile != "" {
+		s.addReloadFile(&group)
+	}
+
+	if len(s.signalHandlers) > 0 {
+		s.addSignalHandlers(&group)
+	}
+
+	// Adopt sockets passed in by systemd socket activation
+	s.activated, err = activatedListeners()
+	if err != nil {
+		return withKind(ErrBind, fmt.Errorf("adopting socket activated listeners: %w", err))
+	}
+
+	// Bind all listeners before serving any of them
+	for _, m := range s.servers {
+		err := s.bind(upg, m)
+		if err != nil {
+			s.closeListeners()
+			s.closeActivated()
+
+			return err
+		}
+	}
+
+	s.closeActivated()
+
+	s.mu.Lock()
+	for _, m := range s.servers {
+		for _, ln := range m.listeners {
+			s.boundAddrs = append(s.boundAddrs, ln.Addr())
+		}
+	}
+	s.mu.Unlock()
+
+	// Let the caller register extra inherited files
+	for _, setup := range s.fdSetup {
+		err := errUpgradesDisabled
+		if tf, ok := upg.(tableflipUpgrader); ok {
+			err = setup(tf.Upgrader)
+		} else if s.upgrades {
+			err = errors.New("graceful: WithFdSetup requires the tableflip upgrader")
+		}
+		if err != nil {
+			s.closeListeners()
+
+			return fmt.Errorf("setting up inherited fds: %w", err)
+		}
+	}
+
+	// Set up servers
+	for _, m := range s.servers {
+		s.addServer(&group, m)
+	}
+
 	// Setup signal handler
 	{
 		var (
 			cancelInterrupt = make(chan struct{})
-			ch              = make(chan os.Signal, 2)
+			ch              = s.shutdownCh
 		)
-		defer close(ch)
 
 		group.Add(
+			"shutdown signals",
 			func() error {
-				signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+				if len(s.shutdownSignals) > 0 {
+					s.notify(ch, s.shutdownSignals...)
+				}
 
 				select {
 				case sig := <-ch:
-					switch sig {
-					case syscall.SIGINT:
-						log.Info("Received SIGINT, exiting gracefully...")
-					case syscall.SIGTERM:
-						log.Info("Received SIGTERM, exiting gracefully...")
+					s.setShutdownCause(ShutdownCause(signalName(sig)))
+					s.mu.Lock()
+					s.shutdownSignal = sig
+					s.mu.Unlock()
+
+					if containsSignal(s.immediateSignals, sig) {
+						s.logger.Warnf("Received %s, shutting down immediately...", signalName(sig))
+						s.forceShutdown()
+						break
 					}
+					s.logger.Infof("Received %s, exiting gracefully...", signalName(sig))
+
+				case <-s.shutdownC:
+					s.setShutdownCause(CauseShutdown)
+					s.logger.Infof("Shutdown requested, exiting gracefully...")
 
 				case <-cancelInterrupt:
+					return nil
 				}
 
+				// The same signal again during the drain closes the servers
+				// immediately
+				s.mu.Lock()
+				first := s.shutdownSignal
+				s.mu.Unlock()
+				go s.forceOnSignal(ch, first, cancelInterrupt)
+
 				return nil
 			},
 			func(e error) {
 				close(cancelInterrupt)
-				signal.Stop(ch)
+				s.stopNotify(ch)
 			},
 		)
 	}
 
 	{
+		waitCtx, cancelWait := context.WithCancel(context.Background())
+
 		group.Add(
+			"upgrader",
 			func() error {
-				// Tell the parent we are ready
-				_ = upg.Ready()
+				// Tell the parent we are ready. If that fails the parent
+				// never hands over, so stop rather than serve alongside it.
+				err := upg.Ready()
+				if err != nil {
+					s.logger.Errorf("Signalling readiness to the parent failed: %v", err)
+					s.emit(Event{Type: EventError, Err: err})
+					return withKind(ErrUpgrade, fmt.Errorf("signalling readiness: %w", err))
+				}
+				s.chmodPIDFile()
+
+				err = s.sd.ready()
+				if err != nil {
+					s.logger.Errorf("Notifying systemd failed: %v", err)
+					s.emit(Event{Type: EventError, Err: err})
+				}
+
+				s.setHealthy(true)
+
+				for _, fn := range s.onReady {
+					fn()
+				}
+				s.setState(StateServing)
+				close(s.ready)
+
+				if upg.HasParent() && len(s.postUpgrade) > 0 {
+					go s.runPostUpgrade(waitCtx, upg)
+				}
 
 				// Wait for children to be ready
 				// (or application shutdown)
 				<-upg.Exit()
 
+				// Exit closing without Stop means a new process took over
+				if atomic.LoadInt32(&s.upgStopped) == 0 {
+					atomic.StoreInt32(&s.handedOff, 1)
+					s.setShutdownCause(CauseUpgrade)
+				}
+
+				return nil
+			},
+			// The group calls every interrupt in turn whichever actor
+			// returned first, and the servers' interrupts drain before
+			// this one runs. Stopping the upgrader closes Exit for good,
+			// so the wait above can't outlive the drain.
+			func(e error) {
+				cancelWait()
+				s.stopUpgrader()
+			},
+		)
+	}
+
+	// Run the caller's actors alongside the servers. They are interrupted
+	// by the drain in the phase for their priority.
+	if s.errGroupWorkers {
+		s.groupWorkers()
+	}
+
+	var actors, workers int
+	reported := make(map[int]*actor)
+	for _, a := range s.actors {
+		a := a
+		a.done = make(chan struct{})
+		stopped := make(chan struct{})
+
+		var name string
+		switch {
+		case a.name != "":
+			name = a.name
+		case a.work != nil:
+			workers++
+			name = fmt.Sprintf("worker %d", workers)
+		default:
+			actors++
+			name = fmt.Sprintf("actor %d", actors)
+		}
+
+		group.Add(
+			name,
+			func() error {
+				err := a.execute()
+				close(a.done)
+
+				if err == nil || a.classify == nil {
+					return err
+				}
+
+				switch a.classify(err) {
+				case SeverityFatal:
+					return err
+				case SeverityError:
+					s.logger.Errorf("Actor failed, continuing: %v", err)
+					s.emit(Event{Type: EventError, Err: err})
+				}
+				a.failed = err
+
+				<-stopped
 				return nil
 			},
 			func(e error) {
-				upg.Stop()
+				s.stop(e)
+				close(stopped)
+			},
+		)
+		reported[len(group.outcomes)-1] = a
+	}
+
+	if s.idleTimeout > 0 {
+		s.addIdleShutdown(&group)
+	}
+
+	if s.selfCheck != nil && s.selfCheckInterval > 0 {
+		s.addSelfHealthCheck(&group)
+	}
+
+	if s.memoryPressure > 0 {
+		s.addMemoryPressureShutdown(&group)
+	}
+
+	if s.startupTimeout > 0 {
+		s.addStartupTimeout(&group)
+	}
+
+	// Shut down when the context is done
+	if ctx.Done() != nil {
+		cancel := make(chan struct{})
+
+		group.Add(
+			"context",
+			func() error {
+				select {
+				case <-ctx.Done():
+					s.setShutdownCause(CauseContext)
+					s.logger.Infof("Context done, exiting gracefully...")
+					return fmt.Errorf("graceful: context done: %w", ctx.Err())
+				case <-cancel:
+					return nil
+				}
+			},
+			func(e error) {
+				close(cancel)
+			},
+		)
+	}
+
+	// Ping the systemd watchdog
+	if interval := s.sd.watchdogInterval(); interval > 0 {
+		cancel := make(chan struct{})
+
+		group.Add(
+			"systemd watchdog",
+			func() error {
+				ticker := s.clock.NewTicker(interval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C():
+						err := s.sd.notify("WATCHDOG=1")
+						if err != nil {
+							s.logger.Errorf("Pinging systemd watchdog failed: %v", err)
+							s.emit(Event{Type: EventError, Err: err})
+						}
+					case <-cancel:
+						return nil
+					}
+				}
+			},
+			func(e error) {
+				close(cancel)
 			},
 		)
 	}
 
 	err = group.Run()
-	if err != nil {
-		log.Fatalf("Error starting service: %s", err)
+
+	report := group.report()
+	for i, a := range reported {
+		if report.Actors[i].Err == nil {
+			report.Actors[i].Err = a.failed
+		}
 	}
+	s.mu.Lock()
+	s.runReport = report
+	s.mu.Unlock()
+
+	s.logger.Infof("Exited, shutdown initiated by %s", s.ShutdownCause())
+
+	// Wait for the upgrader to release its files, which removes
+	// Unix sockets unless they were handed to a new process
+	s.stopUpgrader()
+	<-upg.Exit()
+
+	s.removePIDFile()
+
+	return err
 }
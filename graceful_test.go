@@ -0,0 +1,34 @@
+package graceful
+
+import (
+	"net"
+	"testing"
+)
+
+// testLogger logs lifecycle messages through the test
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Infof(format string, v ...interface{})  { l.t.Logf(format, v...) }
+func (l testLogger) Warnf(format string, v ...interface{})  { l.t.Logf(format, v...) }
+func (l testLogger) Errorf(format string, v ...interface{}) { l.t.Logf(format, v...) }
+func (l testLogger) Fatalf(format string, v ...interface{}) { l.t.Fatalf(format, v...) }
+
+// newTestServer creates a Server that neither upgrades nor notifies systemd,
+// logging through t
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	return New(append([]Option{
+		WithUpgrades(false),
+		WithSystemdNotify(false),
+		WithLogger(testLogger{t}),
+	}, opts...)...)
+}
+
+// listen returns a listener on an ephemeral loopback port
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ln
+}
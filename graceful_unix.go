@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+package graceful
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// upgradesSupported reports whether zero-downtime upgrades work on this platform
+const upgradesSupported = true
+
+var (
+	defaultShutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	defaultUpgradeSignals  = []os.Signal{syscall.SIGHUP}
+)
+
+// isAddrInUse reports whether err means the address is bound by another socket
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// defaultReloadSignal reloads the listeners with WithListenerReload
+var defaultReloadSignal os.Signal = syscall.SIGUSR2
+
+// defaultChildSignal is forwarded to child processes when shutdown wasn't
+// started by a signal
+var defaultChildSignal os.Signal = syscall.SIGTERM
+
+// signalProcess sends sig to pid, returning errProcessGone if it has exited
+func signalProcess(pid int, sig os.Signal) error {
+	ssig, ok := sig.(syscall.Signal)
+	if !ok {
+		ssig = syscall.SIGTERM
+	}
+
+	err := syscall.Kill(pid, ssig)
+	if errors.Is(err, syscall.ESRCH) {
+		return errProcessGone
+	}
+
+	return err
+}
+
+// processAlive reports whether pid is running or not yet reaped
+func processAlive(pid int) bool {
+	return !errors.Is(syscall.Kill(pid, 0), syscall.ESRCH)
+}
+
+// isPrivilegedDenied reports whether err means a port below 1024 needs more
+// privileges than the process has
+func isPrivilegedDenied(err error) bool {
+	return errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,65 @@
+//go:build windows
+// +build windows
+
+package graceful
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// upgradesSupported reports whether zero-downtime upgrades work on this platform.
+// tableflip is Unix-only, so Windows gets graceful shutdown without upgrades.
+const upgradesSupported = false
+
+var (
+	// SIGTERM is delivered for console close, logoff and shutdown events
+	defaultShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	defaultUpgradeSignals  []os.Signal
+)
+
+// wsaeaddrinuse is the Winsock error for an address that is already bound
+const wsaeaddrinuse = syscall.Errno(10048)
+
+// isAddrInUse reports whether err means the address is bound by another socket
+func isAddrInUse(err error) bool {
+	return errors.Is(err, wsaeaddrinuse) || errors.Is(err, syscall.EADDRINUSE)
+}
+
+// defaultReloadSignal reloads the listeners with WithListenerReload. Windows
+// has no spare signal for it.
+var defaultReloadSignal os.Signal
+
+// defaultChildSignal is forwarded to child processes when shutdown wasn't
+// started by a signal. Windows can only kill other processes.
+var defaultChildSignal = os.Kill
+
+// signalProcess kills pid, as Windows can't deliver other signals to it, and
+// then reports it gone as there's no way to tell when it has exited
+func signalProcess(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return errProcessGone
+	}
+	defer p.Release()
+
+	err = p.Kill()
+	if err != nil {
+		return err
+	}
+
+	return errProcessGone
+}
+
+// processAlive reports whether pid is running. Killed processes are reported
+// gone by signalProcess, so none are waited for.
+func processAlive(pid int) bool {
+	return false
+}
+
+// isPrivilegedDenied reports whether err means a port below 1024 needs more
+// privileges than the process has. Windows has no privileged ports.
+func isPrivilegedDenied(err error) bool {
+	return false
+}
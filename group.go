@@ -0,0 +1,99 @@
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Group runs several independently configured Servers together, such as a
+// public and a partner API with their own options. Each Server handles the
+// signals it is configured for, so an OS signal reaches all of them, and
+// Shutdown drains them all under one deadline. Only one of them can have
+// upgrades enabled.
+type Group struct {
+	failFast bool
+
+	mu      sync.Mutex
+	members []groupMember
+}
+
+// groupMember is a Server of a Group and the http servers it serves
+type groupMember struct {
+	s       *Server
+	servers []*http.Server
+}
+
+// NewGroup returns an empty Group. With failFast, the Group shuts every
+// Server down as soon as one of them stops, for whatever reason; otherwise
+// each runs until it is shut down on its own.
+func NewGroup(failFast bool) *Group {
+	return &Group{failFast: failFast}
+}
+
+// Add adds s to the group, to serve servers. It must be called before Serve.
+func (g *Group) Add(s *Server, servers ...*http.Server) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.members = append(g.members, groupMember{s: s, servers: servers})
+}
+
+// Serve runs every Server of the group until they have all stopped, passing
+// ctx to each like ServeContext, and returns their errors in the order they
+// were added.
+func (g *Group) Serve(ctx context.Context) error {
+	g.mu.Lock()
+	members := append([]groupMember(nil), g.members...)
+	g.mu.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		stopOnce sync.Once
+		errs     = make([]error, len(members))
+	)
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m groupMember) {
+			defer wg.Done()
+
+			errs[i] = m.s.ServeContext(ctx, m.servers...)
+
+			if g.failFast {
+				stopOnce.Do(func() {
+					// Serve returns each Server's error
+					for _, other := range members {
+						go func(s *Server) { _ = s.Shutdown(context.Background()) }(other.s)
+					}
+				})
+			}
+		}(i, m)
+	}
+	wg.Wait()
+
+	return joinErrors(errs...)
+}
+
+// Shutdown shuts every Server of the group down at once and waits for them
+// within ctx, returning their errors in the order they were added
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	members := append([]groupMember(nil), g.members...)
+	g.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(members))
+	)
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+
+			errs[i] = s.Shutdown(ctx)
+		}(i, m.s)
+	}
+	wg.Wait()
+
+	return joinErrors(errs...)
+}
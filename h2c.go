@@ -0,0 +1,84 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cState tracks the HTTP/2 cleartext connections of a server. They are
+// hijacked from the http.Server, so its Shutdown neither waits for nor
+// closes them.
+type h2cState struct {
+	active int64
+	ctx    context.Context
+	cancel context.CancelFunc
+	clock  clock
+}
+
+// enableH2C serves m's http server over HTTP/2 cleartext as well as HTTP/1.
+// Shutting the server down sends GOAWAY on its HTTP/2 connections.
+func (s *Server) enableH2C(m *managed) error {
+	err := http2.ConfigureServer(m.http, s.h2s)
+	if err != nil {
+		return fmt.Errorf("configuring h2c: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.h2c = &h2cState{ctx: ctx, cancel: cancel, clock: s.clock}
+
+	handler := m.http.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	m.http.Handler = m.h2c.track(h2c.NewHandler(handler, s.h2s))
+
+	return nil
+}
+
+// track counts the requests and h2c connections served by h, and cancels
+// their streams when the drain gives up on them
+func (h *h2cState) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&h.active, 1)
+		defer atomic.AddInt64(&h.active, -1)
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-h.ctx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// wait waits until no h2c connection is left or ctx is done, and returns the
+// number still active
+func (h *h2cState) wait(ctx context.Context) int {
+	ticker := h.clock.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		active := int(atomic.LoadInt64(&h.active))
+		if active == 0 {
+			return 0
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return active
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package graceful
+
+import "net/http"
+
+// handlerBox holds the handler set with SetHandler, as an atomic.Value
+// needs a consistent concrete type
+type handlerBox struct {
+	h http.Handler
+}
+
+// SetHandler replaces the handler of every http server, before or while
+// serving, keeping the health check, drain response and other wrapping of
+// this package in place. Requests in flight finish on the old handler. The
+// servers' Handler fields must not be assigned once serving, as that would
+// drop the wrapping. A nil h serves http.DefaultServeMux.
+func (s *Server) SetHandler(h http.Handler) {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+
+	s.handler.Store(handlerBox{h: h})
+}
+
+// swappedHandler returns the handler set with SetHandler, or nil
+func (s *Server) swappedHandler() http.Handler {
+	box, _ := s.handler.Load().(handlerBox)
+	return box.h
+}
+
+// serveSwappable makes server serve the SetHandler handler once set, and its
+// own until then
+func (s *Server) serveSwappable(server *http.Server) {
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := s.swappedHandler(); h != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
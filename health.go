@@ -0,0 +1,211 @@
+package graceful
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// HealthHandler returns a handler that responds 200 once the servers are
+// listening and 503 as soon as shutdown begins, for use as a readiness probe
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if !s.isHealthy() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// drainStatus is the payload of DrainStatusHandler
+type drainStatus struct {
+	Draining    bool                `json:"draining"`
+	Connections int                 `json:"connections"`
+	Servers     []serverDrainStatus `json:"servers"`
+}
+
+type serverDrainStatus struct {
+	Addr        string `json:"addr"`
+	Connections int    `json:"connections"`
+}
+
+// DrainStatusHandler returns a handler that reports as JSON whether shutdown
+// is in progress and how many connections each server still has open, to
+// watch a slow drain. Mount it on an admin endpoint.
+func (s *Server) DrainStatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servers := s.snapshot().servers
+		status := drainStatus{
+			Draining: s.ShuttingDown(),
+			Servers:  make([]serverDrainStatus, 0, len(servers)),
+		}
+
+		for _, m := range servers {
+			conns := m.conns.count()
+			status.Connections += conns
+			status.Servers = append(status.Servers, serverDrainStatus{Addr: m.addr, Connections: conns})
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	})
+}
+
+// ShuttingDown reports whether shutdown has begun. During the WithLameDuck
+// period handlers can use it to reject new long-running work while the health
+// check still passes.
+func (s *Server) ShuttingDown() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Draining returns a channel that is closed when shutdown begins. The drain
+// doesn't wait for hijacked connections such as WebSockets, and long-lived
+// responses such as SSE streams hold it up until the shutdown timeout, so
+// their handlers should watch it to finish cleanly, for example by sending a
+// close frame:
+//
+//	select {
+//	case msg := <-updates:
+//		send(msg)
+//	case <-s.Draining():
+//		closeStream()
+//		return
+//	}
+func (s *Server) Draining() <-chan struct{} {
+	return s.drainingC
+}
+
+// info is the payload of InfoHandler
+type info struct {
+	PID              int          `json:"pid"`
+	State            string       `json:"state"`
+	Upgraded         bool         `json:"upgraded"`
+	Upgrades         int          `json:"upgrades"`
+	UpgradesEnabled  bool         `json:"upgrades_enabled"`
+	PIDFile          string       `json:"pid_file,omitempty"`
+	TLS              bool         `json:"tls"`
+	ShutdownTimeout  string       `json:"shutdown_timeout"`
+	ShutdownDeadline string       `json:"shutdown_deadline,omitempty"`
+	DrainDelay       string       `json:"drain_delay,omitempty"`
+	LameDuck         string       `json:"lame_duck,omitempty"`
+	ShutdownSignals  []string     `json:"shutdown_signals"`
+	UpgradeSignals   []string     `json:"upgrade_signals"`
+	ImmediateSignals []string     `json:"immediate_signals,omitempty"`
+	Listening        []string     `json:"listening"`
+	Servers          []serverInfo `json:"servers"`
+}
+
+type serverInfo struct {
+	Addr            string `json:"addr"`
+	ShutdownTimeout string `json:"shutdown_timeout,omitempty"`
+}
+
+// InfoHandler returns a handler that reports as JSON the lifecycle settings
+// in effect and the current state, to confirm what a running process was
+// configured with. Only whether TLS is on is reported, not the certificate
+// files. Until Serve has set the servers up, there are none and TLS and
+// upgrades are reported off. Mount it on an admin endpoint.
+func (s *Server) InfoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := s.snapshot()
+		v := info{
+			PID:              os.Getpid(),
+			State:            s.State().String(),
+			Upgraded:         IsUpgrade(),
+			Upgrades:         s.UpgradeCount(),
+			UpgradesEnabled:  snap.upgrades,
+			PIDFile:          s.pidfile,
+			TLS:              snap.tls,
+			ShutdownTimeout:  s.shutdownTimeout.String(),
+			ShutdownDeadline: durationInfo(s.shutdownDeadline),
+			DrainDelay:       durationInfo(s.drainDelay),
+			LameDuck:         durationInfo(s.lameDuck),
+			ShutdownSignals:  signalList(s.shutdownSignals),
+			UpgradeSignals:   signalList(s.upgradeSignals),
+			ImmediateSignals: signalList(s.immediateSignals),
+			Listening:        []string{},
+			Servers:          make([]serverInfo, 0, len(snap.servers)),
+		}
+		if !snap.upgrades {
+			v.UpgradeSignals = []string{}
+		}
+
+		for _, addr := range s.Addrs() {
+			v.Listening = append(v.Listening, addr.String())
+		}
+		for _, m := range snap.servers {
+			v.Servers = append(v.Servers, serverInfo{Addr: m.addr, ShutdownTimeout: durationInfo(m.shutdownTimeout)})
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	})
+}
+
+// durationInfo formats d for InfoHandler, or "" if it isn't set
+func durationInfo(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return d.String()
+}
+
+// signalList returns the names of sigs for InfoHandler
+func signalList(sigs []os.Signal) []string {
+	names := make([]string, 0, len(sigs))
+	for _, sig := range sigs {
+		names = append(names, signalName(sig))
+	}
+
+	return names
+}
+
+// serveHealth makes server answer the health path with HealthHandler ahead of
+// its own handler
+func (s *Server) serveHealth(server *http.Server) {
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	if mux, ok := next.(*http.ServeMux); ok {
+		_, pattern := mux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: s.healthPath}})
+		if pattern == s.healthPath {
+			s.logger.Warnf("%s is already handled on [%s], the health check takes precedence", s.healthPath, server.Addr)
+		}
+	}
+
+	health := s.HealthHandler()
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == s.healthPath {
+			health.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) isHealthy() bool {
+	return atomic.LoadInt32(&s.healthy) == 1
+}
+
+func (s *Server) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+
+	atomic.StoreInt32(&s.healthy, v)
+}
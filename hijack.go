@@ -0,0 +1,97 @@
+package graceful
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hijackTracker holds the hijacked connections registered with TrackHijacked
+type hijackTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (t *hijackTracker) add(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[c] = struct{}{}
+}
+
+func (t *hijackTracker) remove(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, c)
+}
+
+func (t *hijackTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// closeAll closes the connections still registered
+func (t *hijackTracker) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for c := range t.conns {
+		_ = c.Close()
+	}
+}
+
+// TrackHijacked registers c, hijacked from a request r of one of the http
+// servers, so that with WithHijackDrainTimeout or WithUpgradeConnectionLinger
+// its server waits for it after draining the requests. Call the returned
+// function once c is closed.
+func (s *Server) TrackHijacked(r *http.Request, c net.Conn) (untrack func()) {
+	srv, _ := r.Context().Value(http.ServerContextKey).(*http.Server)
+
+	for _, m := range s.servers {
+		if m.http != nil && m.http == srv {
+			m.hijacked.add(c)
+			return func() { m.hijacked.remove(c) }
+		}
+	}
+
+	return func() {}
+}
+
+// drainHijacked waits up to timeout for m's registered hijacked connections
+// to close and returns the number still open
+func (s *Server) drainHijacked(ctx context.Context, m *managed, timeout time.Duration) int {
+	open := m.hijacked.count()
+	if open == 0 {
+		return 0
+	}
+
+	s.logger.Infof("Waiting up to %s for %d hijacked connections on [%s]", timeout, open, m.addr)
+
+	deadline := s.clock.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := s.clock.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			open = m.hijacked.count()
+			if open == 0 {
+				return 0
+			}
+		case <-deadline.C():
+			return m.hijacked.count()
+		case <-ctx.Done():
+			return m.hijacked.count()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package graceful
+
+import "time"
+
+// addIdleShutdown adds an actor that returns once no server has had an open
+// connection for the idle timeout, shutting the group down
+func (s *Server) addIdleShutdown(group *runGroup) {
+	cancel := make(chan struct{})
+	start := s.clock.Now()
+
+	group.Add(
+		"idle timeout",
+		func() error {
+			timer := s.clock.NewTimer(s.idleTimeout)
+			defer timer.Stop()
+
+			for {
+				select {
+				case <-timer.C():
+				case <-cancel:
+					return nil
+				}
+
+				wait := s.idleTimeout - since(s.clock, s.lastActive(start))
+				if wait <= 0 && s.openConns() == 0 {
+					s.setShutdownCause(CauseIdleTimeout)
+					s.logger.Infof("Idle for %s, exiting gracefully...", s.idleTimeout)
+					return nil
+				}
+
+				// Check again once the newest activity is old enough
+				if wait <= 0 {
+					wait = s.idleTimeout
+				}
+				timer.Reset(wait)
+			}
+		},
+		func(e error) {
+			close(cancel)
+		},
+	)
+}
+
+// lastActive returns when a connection last opened, closed or started a
+// request, or start if none has
+func (s *Server) lastActive(start time.Time) time.Time {
+	last := start
+	for _, m := range s.servers {
+		_, active := m.conns.idle()
+		if active.After(last) {
+			last = active
+		}
+	}
+
+	return last
+}
+
+// openConns returns the number of open connections across all servers
+func (s *Server) openConns() int {
+	var n int
+	for _, m := range s.servers {
+		conns, _ := m.conns.idle()
+		n += conns
+	}
+
+	return n
+}
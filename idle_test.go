@@ -0,0 +1,60 @@
+package graceful
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdleShutdown(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestServer(t, WithIdleShutdown(time.Minute))
+	s.clock = clock
+
+	ln := listen(t)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, srv) }()
+
+	<-s.ready
+	clock.BlockUntil(1)
+
+	// A request halfway through the timeout postpones the shutdown
+	clock.Advance(30 * time.Second)
+	req, err := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	for s.openConns() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(30 * time.Second)
+	clock.BlockUntil(1)
+	select {
+	case err := <-errc:
+		t.Fatalf("Serve returned %v 30s after the last request", err)
+	default:
+	}
+
+	clock.Advance(30 * time.Second)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Serve returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return a minute after the last request")
+	}
+
+	if cause := s.ShutdownCause(); cause != CauseIdleTimeout {
+		t.Errorf("ShutdownCause() = %q, want %q", cause, CauseIdleTimeout)
+	}
+}
@@ -0,0 +1,32 @@
+// Package drain holds the shutdown steps shared by the graceful packages. It
+// depends only on the standard library.
+package drain
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Context returns ctx bounded by timeout, or ctx itself if timeout is zero
+func Context(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Shutdown gracefully shuts srv down within timeout and the deadline of ctx,
+// and closes its remaining connections if that fails
+func Shutdown(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := Context(ctx, timeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		_ = srv.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,120 @@
+package graceful
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// limitRequests makes server serve at most n requests at once. Requests over
+// the limit wait for a slot, and once shutdown begins every request that
+// doesn't hold one, waiting or new, gets a 503 so the in-flight set only
+// shrinks. Drain exempt paths keep waiting for a slot.
+func (s *Server) limitRequests(server *http.Server, n int) {
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	sem := make(chan struct{}, n)
+
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exempt := s.drainExempt(r)
+		if s.ShuttingDown() && !exempt {
+			s.rejectOverload(w)
+			return
+		}
+
+		// Exempt requests wait for a slot until the hard deadline instead
+		draining := s.drainingC
+		if exempt {
+			draining = nil
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-draining:
+			s.rejectOverload(w)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		defer func() { <-sem }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectOverload answers a request turned away by the limiter during shutdown
+func (s *Server) rejectOverload(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+// acceptLimiter accepts at most rate connections a second, with bursts of up
+// to rate. Waiting connections stay in the kernel backlog, and a wait ends
+// as soon as the listener is closed by the drain.
+type acceptLimiter struct {
+	net.Listener
+	rate  float64
+	clock clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newAcceptLimiter(ln net.Listener, rate int, c clock) *acceptLimiter {
+	return &acceptLimiter{
+		Listener: ln,
+		rate:     float64(rate),
+		clock:    c,
+		tokens:   float64(rate),
+		last:     c.Now(),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (ln *acceptLimiter) Accept() (net.Conn, error) {
+	if wait := ln.reserve(); wait > 0 {
+		timer := ln.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-ln.closed:
+			// Accept on the closed listener returns its usual error
+			timer.Stop()
+		}
+	}
+
+	return ln.Listener.Accept()
+}
+
+// reserve takes a token and returns how long to wait until it is available
+func (ln *acceptLimiter) reserve() time.Duration {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	now := ln.clock.Now()
+	ln.tokens += now.Sub(ln.last).Seconds() * ln.rate
+	if ln.tokens > ln.rate {
+		ln.tokens = ln.rate
+	}
+	ln.last = now
+
+	ln.tokens--
+	if ln.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-ln.tokens / ln.rate * float64(time.Second))
+}
+
+func (ln *acceptLimiter) Close() error {
+	ln.closeOnce.Do(func() { close(ln.closed) })
+
+	return ln.Listener.Close()
+}
@@ -0,0 +1,336 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// unixPrefix marks a server address as a Unix domain socket path
+const unixPrefix = "unix:"
+
+// splitAddr returns the network and address to listen on for addr. Addresses
+// prefixed with unixPrefix are Unix sockets whatever the default network is.
+func splitAddr(addr, network string) (string, string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+
+	return network, addr
+}
+
+// validateNetwork rejects networks that can't be listened on for a stream
+func validateNetwork(network string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return nil
+	}
+
+	return fmt.Errorf("graceful: unsupported network %q, want tcp, tcp4, tcp6 or unix", network)
+}
+
+// validateAddr checks that addr can be listened on, so a typo fails before
+// anything is bound
+func validateAddr(addr, network string) error {
+	if addr == "" {
+		return errors.New("graceful: server.Addr must be set")
+	}
+
+	network, address := splitAddr(addr, network)
+	if network == "unix" {
+		if address == "" {
+			return fmt.Errorf("graceful: address %q has no socket path", addr)
+		}
+
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("graceful: invalid address %q: %w", addr, err)
+	}
+
+	err = validateZone(host, network)
+	if err != nil {
+		return fmt.Errorf("graceful: invalid address %q: %w", addr, err)
+	}
+
+	if port == "" {
+		return fmt.Errorf("graceful: address %q has no port", addr)
+	}
+
+	_, err = net.LookupPort(network, port)
+	if err != nil {
+		return fmt.Errorf("graceful: invalid port in address %q: %w", addr, err)
+	}
+
+	return nil
+}
+
+// validateZone checks the zone of a scoped IPv6 host such as fe80::1%eth0
+// names an interface, by name or index, of this host
+func validateZone(host, network string) error {
+	i := strings.LastIndexByte(host, '%')
+	if i < 0 {
+		return nil
+	}
+
+	ip, zone := net.ParseIP(host[:i]), host[i+1:]
+	if ip == nil || ip.To4() != nil {
+		return errors.New("zones are only valid on IPv6 addresses")
+	}
+	if network == "tcp4" {
+		return errors.New("IPv6 address on a tcp4 network")
+	}
+	if zone == "" {
+		return errors.New("empty zone")
+	}
+
+	if index, err := strconv.Atoi(zone); err == nil {
+		_, err = net.InterfaceByIndex(index)
+		if err != nil {
+			return fmt.Errorf("zone %q: %w", zone, err)
+		}
+
+		return nil
+	}
+
+	_, err := net.InterfaceByName(zone)
+	if err != nil {
+		return fmt.Errorf("zone %q: %w", zone, err)
+	}
+
+	return nil
+}
+
+// bind creates the listeners for each of m's addresses
+func (s *Server) bind(upg Upgrader, m *managed) error {
+	// Listeners supplied by the caller are owned by them and not inherited
+	if ln, ok := s.provided[m.http]; ok && m.http != nil {
+		m.listeners = []net.Listener{s.wrapListener(ln)}
+		return nil
+	}
+
+	for _, addr := range m.addrs {
+		ln, err := s.listen(upg, addr)
+		if err != nil {
+			if cerr := s.startCtx.Err(); cerr != nil {
+				err = fmt.Errorf("graceful: startup timeout of %s exceeded: %w", s.startupTimeout, cerr)
+			}
+			return withKind(ErrBind, fmt.Errorf("creating new listener on [%s]: %w", addr, err))
+		}
+
+		wrapped := s.wrapListener(ln)
+		if s.listenerReload && !strings.HasPrefix(addr, unixPrefix) {
+			wrapped = newSwapListener(wrapped, ln, s.clock)
+		}
+		m.listeners = append(m.listeners, wrapped)
+	}
+
+	return nil
+}
+
+// closeListeners closes every listener bound so far
+func (s *Server) closeListeners() {
+	for _, m := range s.servers {
+		for _, ln := range m.listeners {
+			_ = ln.Close()
+		}
+		m.listeners = nil
+	}
+}
+
+// listen returns the listener for addr, inherited from the parent if possible
+func (s *Server) listen(upg Upgrader, addr string) (net.Listener, error) {
+	network, address := splitAddr(addr, s.network)
+	key := s.fdName(network, address)
+
+	// Prefer a socket passed in by systemd, handing it to the upgrader so
+	// it is inherited like any other listener
+	if ln := s.takeActivated(network, address); ln != nil {
+		tl, ok := ln.(InheritableListener)
+		if !ok {
+			return nil, fmt.Errorf("%T can't be inherited", ln)
+		}
+
+		err := upg.AddListener(network, key, tl)
+		if err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+
+		return ln, nil
+	}
+
+	// Bind fresh so an upgrade can move to a changed address. The listener
+	// isn't handed to the upgrader, so the new process binds its own.
+	if s.rebind && network != "unix" {
+		return s.createListener(s.startCtx, network, address)
+	}
+
+	ln, err := upg.ListenWithCallback(network, key, func(network, _ string) (net.Listener, error) {
+		return s.createListener(s.startCtx, network, address)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket file is shared with the upgraded process, so closing our
+	// listener must not unlink it. The upgrader removes it on final shutdown.
+	if ul, ok := ln.(*net.UnixListener); ok && s.upgrades {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	return ln, nil
+}
+
+// tcpKeepAliveListener sets the TCP keep-alive of accepted connections so
+// dead peers are noticed instead of holding up a drain
+type tcpKeepAliveListener struct {
+	*net.TCPListener
+	enabled bool
+	period  time.Duration
+}
+
+func (ln tcpKeepAliveListener) Accept() (net.Conn, error) {
+	c, err := ln.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+
+	_ = c.SetKeepAlive(ln.enabled)
+	if ln.enabled && ln.period > 0 {
+		_ = c.SetKeepAlivePeriod(ln.period)
+	}
+
+	return c, nil
+}
+
+// wrapListener applies the listener options to ln, innermost first: TCP
+// keep-alives, Pause, the accept limit, PROXY protocol decoding, then the
+// WithListenerWrappers in order. TLS from WithTLS is applied on top by the
+// http server.
+func (s *Server) wrapListener(ln net.Listener) net.Listener {
+	ln = s.keepAlive(ln)
+	ln = newPauseListener(ln, s)
+	if s.acceptLimit > 0 {
+		ln = newAcceptLimiter(ln, s.acceptLimit, s.clock)
+	}
+	if s.proxyProtocol {
+		ln = newProxyListener(ln)
+	}
+	for _, wrap := range s.listenerWrappers {
+		ln = wrap(ln)
+	}
+
+	return ln
+}
+
+// keepAlive wraps TCP listeners to apply WithTCPKeepAlive
+func (s *Server) keepAlive(ln net.Listener) net.Listener {
+	if s.tcpKeepAlive == nil {
+		return ln
+	}
+
+	// Unix sockets and custom listeners have no TCP keep-alive to set
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		s.logger.Infof("TCP keep-alive doesn't apply to the %T on [%s], leaving it unchanged", ln, ln.Addr())
+		return ln
+	}
+
+	return tcpKeepAliveListener{TCPListener: tl, enabled: *s.tcpKeepAlive, period: s.tcpKeepAlivePeriod}
+}
+
+// fdName returns the name the listener for address is inherited under.
+// With WithListenerName it is namespaced per address; Unix sockets keep their
+// path because the upgrader unlinks them by it.
+func (s *Server) fdName(network, address string) string {
+	if s.listenerName == "" || network == "unix" {
+		return address
+	}
+
+	return s.listenerName + "/" + address
+}
+
+// createListener binds a fresh listener with the WithListenFunc function if
+// set, or newListener
+func (s *Server) createListener(ctx context.Context, network, address string) (net.Listener, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+	if s.listenFunc != nil {
+		ln, err = s.listenFunc(network, address)
+	} else {
+		ln, err = s.newListener(ctx, network, address)
+	}
+
+	return ln, explainBindErr(network, address, err)
+}
+
+// explainBindErr adds the ways around a permission error binding a
+// privileged port, the usual mistake when serving :80 or :443 as non-root
+func explainBindErr(network, address string, err error) error {
+	if err == nil || network == "unix" || !isPrivilegedDenied(err) {
+		return err
+	}
+
+	_, port, serr := net.SplitHostPort(address)
+	if serr != nil {
+		return err
+	}
+
+	n, perr := net.LookupPort(network, port)
+	if perr != nil || n == 0 || n >= 1024 {
+		return err
+	}
+
+	return fmt.Errorf("%w; port %d is privileged: grant the binary CAP_NET_BIND_SERVICE "+
+		"(setcap cap_net_bind_service=+ep), pass the socket in with systemd socket activation, "+
+		"or bind a port of 1024 or above behind a proxy", err, n)
+}
+
+// newListener binds a fresh listener, retrying while the address is still
+// held by another process if WithBindRetry is set, until ctx is done
+func (s *Server) newListener(ctx context.Context, network, addr string) (net.Listener, error) {
+	backoff := s.bindBackoff
+
+	lc := s.listenConfig
+	if s.rebind || s.listenerReload || s.reusePort {
+		control := lc.Control
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				err := control(network, address, c)
+				if err != nil {
+					return err
+				}
+			}
+
+			return reusePort(network, address, c)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		ln, err := lc.Listen(ctx, network, addr)
+		if err == nil || attempt >= s.bindRetries || !isAddrInUse(err) {
+			return ln, err
+		}
+
+		// Add up to 50% jitter so restarting instances don't retry in lockstep
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		s.logger.Warnf("Address [%s] in use, retrying in %s (%d/%d)", addr, wait.Round(time.Millisecond), attempt+1, s.bindRetries)
+		sleepContext(ctx, s.clock, wait)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		backoff *= 2
+	}
+}
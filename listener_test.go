@@ -0,0 +1,161 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnixListenerThroughWrappers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "http.sock")
+
+	// Each wrapper that only applies to TCP is set and must pass the socket
+	// through
+	s := newTestServer(t,
+		WithTCPKeepAlive(true, time.Minute),
+		WithAcceptLimit(100),
+		WithProxyProtocol(true),
+	)
+	srv := &http.Server{Addr: unixPrefix + path, Handler: remoteAddrServer}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(srv) }()
+	select {
+	case <-s.ready:
+	case err := <-errc:
+		t.Fatalf("Serve returned %v before it was ready", err)
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(c, "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nGET / HTTP/1.0\r\n\r\n")
+	b, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "\r\n\r\n1.2.3.4:1234") {
+		t.Errorf("response %q, want a body of 1.2.3.4:1234", b)
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	waitErr(t, errc, "Serve")
+}
+
+// linkLocal returns a link-local IPv6 address of this host with its zone
+func linkLocal() (net.IP, string, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, "", false
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if ok && ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+				return ipnet.IP, iface.Name, true
+			}
+		}
+	}
+
+	return nil, "", false
+}
+
+func TestValidateZonedAddr(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skipf("no interfaces: %v", err)
+	}
+	name, index := ifaces[0].Name, fmt.Sprint(ifaces[0].Index)
+
+	tests := []struct {
+		addr    string
+		network string
+		valid   bool
+	}{
+		{"[fe80::1%" + name + "]:8080", "tcp", true},
+		{"[fe80::1%" + index + "]:8080", "tcp", true},
+		{"[fe80::1%" + name + "]:8080", "tcp6", true},
+		{"[fe80::1%" + name + "]:8080", "tcp4", false},
+		{"[fe80::1%no-such-interface]:8080", "tcp", false},
+		{"[fe80::1%]:8080", "tcp", false},
+		{"[127.0.0.1%" + name + "]:8080", "tcp", false},
+		{"[fe80::1%" + name + "]", "tcp", false},
+	}
+
+	for _, tt := range tests {
+		err := validateAddr(tt.addr, tt.network)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateAddr(%q, %q) = %v, want valid %t", tt.addr, tt.network, err, tt.valid)
+		}
+	}
+}
+
+func TestServeZonedAddr(t *testing.T) {
+	ip, zone, ok := linkLocal()
+	if !ok {
+		t.Skip("no link-local IPv6 address")
+	}
+
+	s := newTestServer(t)
+	srv := &http.Server{
+		Addr:    net.JoinHostPort(ip.String()+"%"+zone, "0"),
+		Handler: remoteAddrServer,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(srv) }()
+	select {
+	case <-s.ready:
+	case err := <-errc:
+		t.Fatalf("Serve returned %v before it was ready", err)
+	}
+
+	// The bound address keeps the zone, so it can be dialled as reported
+	addr, ok := s.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.Equal(ip) || addr.Zone != zone {
+		t.Fatalf("Addr() = %v, want %s%%%s", s.Addr(), ip, zone)
+	}
+
+	c, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(c, "GET / HTTP/1.0\r\n\r\n")
+	b, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "%" + zone + "]"; !strings.Contains(string(b), want) {
+		t.Errorf("response %q, want a RemoteAddr in zone %s", b, zone)
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	waitErr(t, errc, "Serve")
+}
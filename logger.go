@@ -0,0 +1,101 @@
+package graceful
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codechimp-io/log"
+)
+
+// Logger is the logging interface used for lifecycle messages
+type Logger interface {
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+	Fatalf(format string, v ...interface{})
+}
+
+// FieldLogger is a Logger that can attach structured fields to its messages.
+// Server uses it to tag lifecycle messages with the process they came from;
+// other loggers get the same details as a message prefix.
+type FieldLogger interface {
+	Logger
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// defaultLogger logs through github.com/codechimp-io/log
+type defaultLogger struct {
+	fields map[string]interface{}
+}
+
+func (l defaultLogger) Infof(format string, v ...interface{}) {
+	log.Logger.Info().Fields(l.fields).Msgf(format, v...)
+}
+
+func (l defaultLogger) Warnf(format string, v ...interface{}) {
+	log.Logger.Warn().Fields(l.fields).Msgf(format, v...)
+}
+
+func (l defaultLogger) Errorf(format string, v ...interface{}) {
+	log.Logger.Error().Fields(l.fields).Msgf(format, v...)
+}
+
+func (l defaultLogger) Fatalf(format string, v ...interface{}) {
+	log.Logger.Fatal().Fields(l.fields).Msgf(format, v...)
+}
+
+// WithFields returns a logger adding fields to every message
+func (l defaultLogger) WithFields(fields map[string]interface{}) Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	return defaultLogger{fields: merged}
+}
+
+// prefixLogger prefixes the messages of a Logger that doesn't support fields
+type prefixLogger struct {
+	Logger
+	prefix string
+}
+
+func (l prefixLogger) Infof(format string, v ...interface{}) {
+	l.Logger.Infof(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Warnf(format string, v ...interface{}) {
+	l.Logger.Warnf(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Errorf(l.prefix+format, v...)
+}
+
+func (l prefixLogger) Fatalf(format string, v ...interface{}) {
+	l.Logger.Fatalf(l.prefix+format, v...)
+}
+
+// processLogger returns logger tagged with the pid and whether this process
+// was started by an upgrade, so interleaved messages from the old and new
+// process can be told apart
+func processLogger(logger Logger, upgraded bool) Logger {
+	pid := os.Getpid()
+
+	if fl, ok := logger.(FieldLogger); ok {
+		return fl.WithFields(map[string]interface{}{
+			"pid":      pid,
+			"upgraded": upgraded,
+		})
+	}
+
+	prefix := fmt.Sprintf("[pid %d] ", pid)
+	if upgraded {
+		prefix = fmt.Sprintf("[pid %d, upgraded] ", pid)
+	}
+
+	return prefixLogger{Logger: logger, prefix: prefix}
+}
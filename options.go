@@ -0,0 +1,833 @@
+package graceful
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudflare/tableflip"
+	"golang.org/x/net/http2"
+)
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithShutdownTimeout sets how long to wait for connections to drain on shutdown
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = d
+	}
+}
+
+// WithPIDFile sets the PID file used to coordinate graceful upgrades. It is
+// written once the listeners are bound and serving, and removed on exit unless
+// a new process has taken it over. A process started other than by an upgrade
+// fails with ErrBind while the file names another running process, see
+// WithWaitForLock. The file is never locked, so this only catches a second
+// instance started after the first has written it.
+func WithPIDFile(path string) Option {
+	return func(s *Server) {
+		s.pidfile = path
+	}
+}
+
+// WithLogger sets the logger used for lifecycle messages
+func WithLogger(l Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithTLS serves TLS using the given certificate and key files, which are
+// reloaded from disk on the upgrade signals, SIGHUP by default, also when
+// upgrades are disabled
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// WithShutdownSignals sets the signals that trigger a graceful shutdown.
+// The same signal again during the drain closes the servers immediately,
+// while other shutdown signals are ignored, and a signal received during an
+// upgrade waits for the upgrade to finish.
+func WithShutdownSignals(sigs ...os.Signal) Option {
+	return func(s *Server) {
+		s.shutdownSignals = sigs
+	}
+}
+
+// WithUpgradeSignals sets the signals that trigger a graceful upgrade, or
+// only reload the WithTLS certificate when upgrades are disabled
+func WithUpgradeSignals(sigs ...os.Signal) Option {
+	return func(s *Server) {
+		s.upgradeSignals = sigs
+	}
+}
+
+// WithPreShutdown adds a hook run before the servers stop accepting connections.
+// Its error is logged but does not prevent shutdown.
+func WithPreShutdown(hook func(context.Context) error) Option {
+	return func(s *Server) {
+		s.preShutdown = append(s.preShutdown, hook)
+	}
+}
+
+// WithPostShutdown adds a hook run after all servers have been shut down
+func WithPostShutdown(hook func(context.Context) error) Option {
+	return func(s *Server) {
+		s.postShutdown = append(s.postShutdown, hook)
+	}
+}
+
+// WithDrainDelay keeps serving for d after a shutdown is triggered and the
+// pre-shutdown hooks have run, before the servers stop accepting connections.
+// It follows WithProbePropagationDelay.
+func WithDrainDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = d
+	}
+}
+
+// WithSystemdNotify enables or disables systemd readiness notifications.
+// They are enabled by default and only sent when NOTIFY_SOCKET is set.
+func WithSystemdNotify(enabled bool) Option {
+	return func(s *Server) {
+		s.systemdNotify = enabled
+	}
+}
+
+// WithFdSetup adds a callback that can register extra files to inherit across
+// upgrades. It runs after the upgrader is created and before it is marked ready.
+func WithFdSetup(setup func(*tableflip.Upgrader) error) Option {
+	return func(s *Server) {
+		s.fdSetup = append(s.fdSetup, setup)
+	}
+}
+
+// WithReadyCallback adds a callback invoked once all listeners are bound and
+// serving, both on first launch and in the new process after an upgrade
+func WithReadyCallback(fn func()) Option {
+	return func(s *Server) {
+		s.onReady = append(s.onReady, fn)
+	}
+}
+
+// WithUpgrades enables or disables zero-downtime upgrades. When disabled no
+// PID file is written, upgrade signals only reload a WithTLS certificate
+// and listeners are bound directly, while graceful shutdown behaves the same.
+func WithUpgrades(enabled bool) Option {
+	return func(s *Server) {
+		s.upgrades = enabled
+	}
+}
+
+// WithUpgradeTimeout bounds how long an upgrade waits for the new process to
+// become ready. On timeout the new process is killed and the current one keeps
+// serving. Defaults to tableflip.DefaultUpgradeTimeout.
+func WithUpgradeTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.upgradeTimeout = d
+	}
+}
+
+// WithEventHandler adds a handler called synchronously for every lifecycle event
+func WithEventHandler(handler func(Event)) Option {
+	return func(s *Server) {
+		s.eventHandlers = append(s.eventHandlers, handler)
+	}
+}
+
+// WithBaseContext makes ctx the parent of every request context and cancels
+// it once the servers stop accepting connections, after the lame duck, probe
+// propagation and drain delays, during which the process is still serving new
+// requests. A BaseContext already set on a server is kept, and its context is
+// cancelled on shutdown as well.
+// server.Shutdown already stops new connections and waits for in-flight
+// requests; this additionally lets long-running and streaming handlers notice
+// the drain through r.Context().Done(). Handlers that pass r.Context() to work
+// which must complete will see it cancelled, which is why this is opt-in.
+func WithBaseContext(ctx context.Context) Option {
+	return func(s *Server) {
+		s.baseCtx = ctx
+	}
+}
+
+// WithShutdownDeadline bounds the whole shutdown sequence: pre-shutdown hooks,
+// drain delay, server shutdown and post-shutdown hooks all share a context with
+// this deadline. Steps reached after it expires get an already-done context so
+// they can clean up quickly. Zero means no overall deadline.
+func WithShutdownDeadline(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownDeadline = d
+	}
+}
+
+// WithAddrs serves the http server on each of addrs instead of server.Addr,
+// for example on both IPv4 and IPv6. Every address gets its own inherited
+// listener and all of them are drained on shutdown. It requires Serve to be
+// given exactly one http server.
+func WithAddrs(addrs ...string) Option {
+	return func(s *Server) {
+		s.addrs = addrs
+	}
+}
+
+// WithBindRetry retries binding a listener up to attempts more times when the
+// address is still in use, for example by a process that is shutting down.
+// The wait starts at backoff, doubles each time and is jittered. Other bind
+// errors fail immediately. By default binding is not retried.
+func WithBindRetry(attempts int, backoff time.Duration) Option {
+	return func(s *Server) {
+		s.bindRetries = attempts
+		s.bindBackoff = backoff
+	}
+}
+
+// WithDisableKeepAlivesOnDrain controls whether HTTP keep-alives are disabled
+// as soon as shutdown starts, so idle and busy connections close after their
+// current request instead of carrying new ones into the drain. Enabled by default.
+func WithDisableKeepAlivesOnDrain(enabled bool) Option {
+	return func(s *Server) {
+		s.disableKeepAlives = enabled
+	}
+}
+
+// WithListenerName namespaces the inherited listeners under name, so several
+// graceful servers sharing a parent process don't pick up each other's
+// sockets. Each address is inherited as name/address.
+func WithListenerName(name string) Option {
+	return func(s *Server) {
+		s.listenerName = name
+	}
+}
+
+// WithMinUpgradeInterval drops upgrade signals that arrive sooner than d after
+// the previous upgrade attempt, protecting against restart storms. The first
+// signal always triggers an upgrade.
+func WithMinUpgradeInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.minUpgradeInterval = d
+	}
+}
+
+// WithIdleShutdown shuts the servers down gracefully once they have had no
+// open connections for d. Every new connection or request restarts the wait.
+// Only http servers are tracked, so it is meant for scale-to-zero workers.
+func WithIdleShutdown(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithUpgradeErrorHandler calls fn with the error of each failed upgrade, for
+// alerting or rolling back a staged binary. The process keeps serving after a
+// failed upgrade whatever fn does, short of exiting itself.
+func WithUpgradeErrorHandler(fn func(error)) Option {
+	return func(s *Server) {
+		s.upgradeErrorHandlers = append(s.upgradeErrorHandlers, fn)
+	}
+}
+
+// WithNetwork sets the network addresses are listened on: tcp (the default),
+// tcp4, tcp6 or unix. Addresses prefixed with "unix:" are always Unix sockets.
+func WithNetwork(network string) Option {
+	return func(s *Server) {
+		s.network = network
+	}
+}
+
+// WithServerTimeouts sets the read, write, idle and read header timeouts of
+// the http servers that leave them unset. Connections without timeouts can
+// hold up a drain until the shutdown timeout force-closes them.
+func WithServerTimeouts(read, write, idle, readHeader time.Duration) Option {
+	return func(s *Server) {
+		s.timeouts = serverTimeouts{read: read, write: write, idle: idle, readHeader: readHeader}
+	}
+}
+
+// WithPIDFileMode sets the permissions of the PID file, which is otherwise
+// only readable by its owner
+func WithPIDFileMode(mode os.FileMode) Option {
+	return func(s *Server) {
+		s.pidFileMode = mode
+	}
+}
+
+// WithPIDFileDir creates the PID file's parent directory with mode if it
+// doesn't exist yet
+func WithPIDFileDir(mode os.FileMode) Option {
+	return func(s *Server) {
+		s.pidDirMode = mode
+	}
+}
+
+// WithRebindOnUpgrade makes every process bind its TCP listeners from the
+// configured addresses instead of inheriting them, so an upgrade can change
+// ports. The new process binds alongside the old one with SO_REUSEPORT where
+// supported; connections still queued on the old listeners when they close
+// during its drain are reset. Unix sockets are still inherited.
+func WithRebindOnUpgrade(enabled bool) Option {
+	return func(s *Server) {
+		s.rebind = enabled
+	}
+}
+
+// WithForceCloseOnTimeout sets whether connections still open when the
+// shutdown timeout expires are closed. It is enabled by default; a clean
+// drain never closes anything.
+func WithForceCloseOnTimeout(enabled bool) Option {
+	return func(s *Server) {
+		s.forceClose = enabled
+	}
+}
+
+// WithPostUpgrade calls fn in a process started by an upgrade once it has
+// taken over and the old process has exited, for work like re-registering
+// with service discovery. It doesn't run on the initial start.
+func WithPostUpgrade(fn func()) Option {
+	return func(s *Server) {
+		s.postUpgrade = append(s.postUpgrade, fn)
+	}
+}
+
+// WithShutdownParentContext derives the shutdown budget from ctx, so the drain
+// is aborted as soon as ctx is done instead of running its full timeout. It
+// doesn't start a shutdown; use ServeContext for that.
+func WithShutdownParentContext(ctx context.Context) Option {
+	return func(s *Server) {
+		s.shutdownParent = ctx
+	}
+}
+
+// WithLameDuck keeps the health check passing for d after shutdown begins,
+// giving load balancers that only probe health time to notice while handlers
+// check ShuttingDown to turn new work away. The health check fails after d,
+// then the WithDrainDelay wait runs before the servers are shut down.
+func WithLameDuck(d time.Duration) Option {
+	return func(s *Server) {
+		s.lameDuck = d
+	}
+}
+
+// WithH2C serves the http servers over HTTP/2 cleartext with h2s as well as
+// HTTP/1. A drain sends GOAWAY and waits for the HTTP/2 streams within the
+// shutdown timeout, after which their contexts are cancelled. It has no effect
+// with WithTLS, which negotiates HTTP/2 itself.
+func WithH2C(h2s *http2.Server) Option {
+	return func(s *Server) {
+		if h2s == nil {
+			h2s = &http2.Server{}
+		}
+		s.h2s = h2s
+	}
+}
+
+// WithConnStateHook calls fn for every connection state transition of the
+// http servers, after the package's own tracking and the server's ConnState.
+// Like ConnState, fn is called from many goroutines at once.
+func WithConnStateHook(fn func(net.Conn, http.ConnState)) Option {
+	return func(s *Server) {
+		s.connStateHooks = append(s.connStateHooks, fn)
+	}
+}
+
+// WithTCPKeepAlive enables or disables TCP keep-alive on accepted connections,
+// probing every period when enabled and period is non-zero. Keep-alives
+// detect dead peers whose half-open connections would otherwise hold up a
+// drain until the shutdown timeout.
+func WithTCPKeepAlive(enabled bool, period time.Duration) Option {
+	return func(s *Server) {
+		s.tcpKeepAlive = &enabled
+		s.tcpKeepAlivePeriod = period
+	}
+}
+
+// WithHealthPath serves HealthHandler on path of every http server, ahead of
+// the server's own handler, which still receives all other paths. If the
+// handler also serves path, the health check takes precedence and a warning is
+// logged.
+func WithHealthPath(path string) Option {
+	return func(s *Server) {
+		s.healthPath = path
+	}
+}
+
+// WithReloadFile upgrades gracefully when the file at path is created or
+// modified, as an alternative to sending SIGHUP. The file is polled, and a
+// burst of writes triggers a single upgrade once it stops changing.
+func WithReloadFile(path string) Option {
+	return func(s *Server) {
+		s.reloadFile = path
+	}
+}
+
+// WithStartupTimeout fails Serve if the servers aren't bound and ready within
+// d of it being called, instead of hanging on a wedged bind or address lookup
+func WithStartupTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.startupTimeout = d
+	}
+}
+
+// WithDrainResponse answers requests on connections accepted after shutdown
+// began with status and a Retry-After of retryAfter, rounded up to seconds,
+// until the listeners close. Requests on earlier connections are served as
+// usual. It gives clients that ignore the health check a retryable response
+// during WithLameDuck and WithDrainDelay.
+func WithDrainResponse(status int, retryAfter time.Duration) Option {
+	return func(s *Server) {
+		s.drainStatus = status
+		s.drainRetryAfter = retryAfter
+	}
+}
+
+// WithMaintenancePage answers like WithDrainResponse, with page as the body
+// instead of the status text, such as an HTML page telling users to retry.
+// Its content type is detected from the page. The Retry-After is one second
+// unless WithDrainResponse sets another.
+func WithMaintenancePage(page []byte, status int) Option {
+	return func(s *Server) {
+		s.drainStatus = status
+		s.maintenancePage = page
+	}
+}
+
+// WithProxyProtocol decodes the PROXY protocol header, version 1 or 2, that a
+// load balancer sends ahead of each connection, so RemoteAddr is the real
+// client. Connections without a valid header are closed. With WithTLS the
+// header is read ahead of the TLS handshake.
+func WithProxyProtocol(enabled bool) Option {
+	return func(s *Server) {
+		s.proxyProtocol = enabled
+	}
+}
+
+// WithSignalHandler runs handler each time sig is received, such as SIGUSR1 to
+// reopen log files, without shutting down or upgrading. Handlers run one at a
+// time in the order they were added, and sig can't also be a shutdown or
+// upgrade signal.
+func WithSignalHandler(sig os.Signal, handler func()) Option {
+	return func(s *Server) {
+		s.signalHandlers = append(s.signalHandlers, signalHandler{sig: sig, fn: handler})
+	}
+}
+
+// WithDrainProgress calls fn every interval while the servers drain with the
+// number of connections still open, and once more at the end with the number
+// that had to be force-closed, so zero means the drain completed
+func WithDrainProgress(interval time.Duration, fn func(remaining int)) Option {
+	return func(s *Server) {
+		if interval > 0 {
+			s.drainProgress = fn
+			s.drainProgressInterval = interval
+		}
+	}
+}
+
+// WithStartupLog replaces the "Listening on" message logged for each listener
+// with fn, which receives the resolved address and the process ID. A function
+// that does nothing suppresses the message.
+func WithStartupLog(fn func(addr net.Addr, pid int)) Option {
+	return func(s *Server) {
+		s.startupLog = fn
+	}
+}
+
+// WithUpgradePrecondition runs check before each upgrade. If it returns an
+// error the upgrade is skipped and the process keeps serving, for example when
+// the new binary fails a checksum or memory is too short to run two processes.
+func WithUpgradePrecondition(check func() error) Option {
+	return func(s *Server) {
+		s.upgradePreconditions = append(s.upgradePreconditions, check)
+	}
+}
+
+// WithListenFunc creates the listeners that aren't inherited from the parent
+// process with fn instead of binding them directly, for example to inject a
+// fake in tests or set socket options. With upgrades enabled the listeners
+// are still handed to the new process, so fn must return listeners with a
+// File method such as *net.TCPListener.
+func WithListenFunc(fn func(network, addr string) (net.Listener, error)) Option {
+	return func(s *Server) {
+		s.listenFunc = fn
+	}
+}
+
+// WithListenConfig binds the listeners that aren't inherited from the parent
+// process with lc, such as to set socket options in its Control function.
+// Control runs ahead of the SO_REUSEPORT this package sets itself. The
+// options stay on sockets passed on by an upgrade, which the new process
+// doesn't bind again, but lc.KeepAlive only applies in the process that
+// bound them; use WithTCPKeepAlive instead. WithListenFunc takes precedence.
+func WithListenConfig(lc net.ListenConfig) Option {
+	return func(s *Server) {
+		s.listenConfig = lc
+	}
+}
+
+// WithDrainCountdown sets how often the time left until the shutdown timeout
+// and the open connections are logged while a server drains. It defaults to
+// 5s, and zero disables the countdown.
+func WithDrainCountdown(interval time.Duration) Option {
+	return func(s *Server) {
+		s.countdownInterval = interval
+	}
+}
+
+// WithHandoffComplete calls fn in the old process once it has handed over to
+// the new one and drained, just before it exits. It isn't called when the
+// process shuts down for any other reason.
+func WithHandoffComplete(fn func()) Option {
+	return func(s *Server) {
+		s.handoffComplete = append(s.handoffComplete, fn)
+	}
+}
+
+// WithUpgrader selects how upgrades start the new process and hand it the
+// listeners. newUpgrader is called once by Serve; it defaults to
+// TableflipUpgrader, and ExecUpgrader is a simpler alternative.
+//
+// Tests can exercise the upgrade path without starting processes by passing
+// a fake, like the fakeUpgrader of this package's tests. ListenWithCallback
+// should call the callback, and Exit must be closed once Upgrade succeeds or
+// Stop is called:
+//
+//	fake := newFakeUpgrader()
+//	s := graceful.New(graceful.WithUpgrader(func(graceful.UpgraderOptions) (graceful.Upgrader, error) {
+//		return fake, nil
+//	}))
+//	go s.Serve(srv)
+//	s.Signal(syscall.SIGHUP) // calls fake.Upgrade
+func WithUpgrader(newUpgrader func(UpgraderOptions) (Upgrader, error)) Option {
+	return func(s *Server) {
+		s.newUpgrader = newUpgrader
+	}
+}
+
+// WithShutdownReserve ends each server's drain d before its shutdown timeout
+// or the WithShutdownDeadline deadline, whichever is sooner, and uses the rest
+// to force-close the connections that remain. This keeps the process within a
+// hard limit such as Kubernetes' termination grace period while still
+// draining politely first.
+func WithShutdownReserve(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownReserve = d
+	}
+}
+
+// WithMaxConcurrentRequests limits each http server to n requests at a time.
+// Requests over the limit wait for one to finish. Once shutdown begins, new
+// and waiting requests are answered with 503 Service Unavailable so only the
+// requests already running remain to drain. The health check isn't limited.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(s *Server) {
+		s.maxRequests = n
+	}
+}
+
+// WithHijackDrainTimeout gives the hijacked connections registered with
+// TrackHijacked, such as WebSockets, up to d to close after their server has
+// drained its requests. Those still open afterwards are closed with the
+// server when WithForceCloseOnTimeout is enabled.
+func WithHijackDrainTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.hijackTimeout = d
+	}
+}
+
+// WithWorkers runs each worker alongside the servers with a context that is
+// cancelled once shutdown has drained the servers, like an actor added with
+// Add, and waits up to the shutdown timeout for them to return. A worker
+// returning an error shuts the process down gracefully, and Serve returns the
+// errors of every worker. A worker that returns nil before shutdown is
+// finished and leaves the others running.
+func WithWorkers(workers ...func(ctx context.Context) error) Option {
+	return func(s *Server) {
+		for _, w := range workers {
+			s.addWorker(w)
+		}
+	}
+}
+
+// WithUpgradeDrainTimeout sets the shutdown timeout of the old process after
+// it hands over to a new one in an upgrade, in place of the shutdown timeouts
+// used when the process is stopped. The new process already serves new
+// connections, so the old one can give long requests more time to finish.
+func WithUpgradeDrainTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.upgradeDrainTimeout = d
+	}
+}
+
+// WithListenerWrappers wraps every listener the servers accept from, such as
+// to filter connections. Wrappers are applied in order on top of TCP
+// keep-alives, the accept limit and PROXY protocol decoding, and beneath TLS
+// from WithTLS, so a connection is read as PROXY header, then TLS, then HTTP.
+// The servers accept from and close the outermost listener, so a wrapper's
+// Close must close the listener it wraps; the inner listener is what an
+// upgrade passes on.
+func WithListenerWrappers(wrappers ...func(net.Listener) net.Listener) Option {
+	return func(s *Server) {
+		s.listenerWrappers = append(s.listenerWrappers, wrappers...)
+	}
+}
+
+// WithContextValues sets fn as the BaseContext of servers that don't have
+// one, so every request context carries the values it returns, such as a
+// logger or the process generation. With WithBaseContext the context from fn
+// is also cancelled as soon as graceful shutdown starts; its values and
+// deadline are kept, while the values of the WithBaseContext context are not
+// seen by requests. A server's own BaseContext takes precedence over fn.
+func WithContextValues(fn func(net.Listener) context.Context) Option {
+	return func(s *Server) {
+		s.contextValues = fn
+	}
+}
+
+// WithAcceptLimit accepts at most rate new connections a second on each
+// listener, allowing bursts of up to rate. Connections over the limit wait in
+// the listen backlog rather than being refused. The drain closes the
+// listeners as usual, which ends any wait, and the limit doesn't affect the
+// listeners passed on by an upgrade. Zero, the default, means no limit.
+func WithAcceptLimit(rate int) Option {
+	return func(s *Server) {
+		s.acceptLimit = rate
+	}
+}
+
+// WithBeforeExit adds fn to run as the last step of Serve, after the drain,
+// the post-shutdown hooks and the upgrader teardown, such as to flush
+// buffered logs or metrics. Functions run once in the order they were added,
+// however Serve returns, including when it fails to start, and before
+// Shutdown and Stop return.
+func WithBeforeExit(fn func()) Option {
+	return func(s *Server) {
+		s.beforeExit = append(s.beforeExit, fn)
+	}
+}
+
+// WithRequireHandler makes Serve fail for an http server without a Handler
+// rather than warn and serve http.DefaultServeMux, which holds whatever any
+// imported package registered on it.
+func WithRequireHandler(enabled bool) Option {
+	return func(s *Server) {
+		s.requireHandler = enabled
+	}
+}
+
+// WithChildSignaling forwards the shutdown signal, or the default
+// termination signal when shutdown wasn't started by one, to the child
+// processes registered with RegisterChild as soon as shutdown begins. Once
+// the servers are drained it waits for the children within the shutdown
+// timeout. Only registered PIDs are signalled, never the process group, so
+// the new process of an upgrade isn't. Waiting relies on the children being
+// reaped, such as by exec.Cmd.Wait.
+func WithChildSignaling(enabled bool) Option {
+	return func(s *Server) {
+		s.childSignaling = enabled
+	}
+}
+
+// WithListenerReload runs ReloadListeners on sig, SIGUSR2 if sig is nil,
+// rebinding the listeners within this process rather than starting a new one
+// as an upgrade does. Errors are logged and the old listeners kept. Listeners
+// are bound with SO_REUSEPORT so the old and new ones can share the address.
+// Windows has no default signal, so ReloadListeners has to be called there.
+func WithListenerReload(sig os.Signal) Option {
+	return func(s *Server) {
+		s.listenerReload = true
+
+		if sig == nil {
+			sig = defaultReloadSignal
+		}
+		if sig == nil {
+			return
+		}
+
+		s.signalHandlers = append(s.signalHandlers, signalHandler{sig: sig, fn: func() {
+			err := s.ReloadListeners()
+			if err != nil {
+				s.logger.Errorf("Reloading the listeners failed: %v", err)
+				s.emit(Event{Type: EventError, Err: err})
+			}
+		}})
+	}
+}
+
+// WithDrainExemptPaths keeps serving requests whose path starts with one of
+// prefixes during the drain, where WithDrainResponse and
+// WithMaxConcurrentRequests would turn them away, such as webhook
+// acknowledgements. They are still bound by the shutdown timeout, after
+// which their connections are closed like any other.
+func WithDrainExemptPaths(prefixes ...string) Option {
+	return func(s *Server) {
+		s.drainExemptPaths = append(s.drainExemptPaths, prefixes...)
+	}
+}
+
+// WithSelfHealthCheck runs check every interval while serving and shuts down
+// gracefully once it has failed WithSelfHealthCheckFailures times in a row,
+// three by default, so a process that knows it can't recover is replaced.
+// Serve then returns the last error of check.
+func WithSelfHealthCheck(interval time.Duration, check func() error) Option {
+	return func(s *Server) {
+		s.selfCheckInterval = interval
+		s.selfCheck = check
+	}
+}
+
+// WithSelfHealthCheckFailures sets how many consecutive failures of the
+// WithSelfHealthCheck check shut the server down
+func WithSelfHealthCheckFailures(n int) Option {
+	return func(s *Server) {
+		s.selfCheckFailures = n
+	}
+}
+
+// WithReusePort binds the TCP listeners with SO_REUSEPORT, so several
+// processes can serve the same port and the kernel spreads connections across
+// them. Upgrades inherit the listener rather than binding it again, so this
+// matters for running processes side by side, not for the handoff. It
+// doesn't apply to WithListenFunc and does nothing on platforms without
+// SO_REUSEPORT.
+func WithReusePort(enabled bool) Option {
+	return func(s *Server) {
+		s.reusePort = enabled
+	}
+}
+
+// WithVirtualHost serves requests for host on every http server with handler,
+// leaving other hosts to the server's own handler. A host of "*.example.com"
+// matches any subdomain of example.com, with exact hosts tried first and then
+// the longest matching wildcard; the port of the Host header is ignored. The
+// virtual hosts sit inside the drain response, request limit and health check,
+// which apply to every host alike.
+func WithVirtualHost(host string, handler http.Handler) Option {
+	return func(s *Server) {
+		s.virtualHosts = append(s.virtualHosts, virtualHost{host: host, handler: handler})
+	}
+}
+
+// WithUpgradeConnectionLinger keeps the old process of an upgrade running for
+// up to d while connections registered with TrackHijacked are open, such as
+// streams that would otherwise be closed by the WithHijackDrainTimeout drain.
+// It replaces that timeout only for the drain after an upgrade handoff; other
+// connections drain as usual, and the process exits as soon as the
+// registered ones close.
+func WithUpgradeConnectionLinger(d time.Duration) Option {
+	return func(s *Server) {
+		s.upgradeLinger = d
+	}
+}
+
+// WithSignalActions sets what each signal in actions does, replacing the
+// WithShutdownSignals and WithUpgradeSignals, for example:
+//
+//	graceful.WithSignalActions(map[os.Signal]graceful.Action{
+//		syscall.SIGTERM: graceful.ActionShutdown,
+//		syscall.SIGQUIT: graceful.ActionImmediateShutdown,
+//		syscall.SIGHUP:  graceful.ActionUpgrade,
+//		syscall.SIGUSR1: graceful.ActionFunc(dumpState),
+//	})
+//
+// DefaultSignalActions and InteractiveSignalActions return preset maps.
+// Handlers from WithSignalHandler run alongside ActionFunc ones but can't be
+// on a signal with another action, and Serve fails for an Action that is zero
+// or has a nil function.
+func WithSignalActions(actions map[os.Signal]Action) Option {
+	return func(s *Server) {
+		s.signalActions = make(map[os.Signal]Action, len(actions))
+		for sig, action := range actions {
+			s.signalActions[sig] = action
+		}
+	}
+}
+
+// WithDrainDiagnostics records the method, path and start of every request,
+// so that a drain that times out logs the requests it force-closes, longest
+// running first, such as "force-closed 2 requests: GET /export (28s), POST
+// /report (12s)". The bookkeeping costs a little on every request.
+func WithDrainDiagnostics(enabled bool) Option {
+	return func(s *Server) {
+		s.drainDiagnostics = enabled
+	}
+}
+
+// WithMemoryPressureShutdown shuts down gracefully once the cgroup's memory
+// pressure reaches threshold, the percentage of the last ten seconds in which
+// some task was stalled waiting for memory, so the process is replaced
+// cleanly before the OOM killer ends it. Serve then returns an error. It
+// reads the cgroup v2 memory.pressure file each second and does nothing where
+// that isn't available.
+func WithMemoryPressureShutdown(threshold float64) Option {
+	return func(s *Server) {
+		s.memoryPressure = threshold
+	}
+}
+
+// WithUpgradeEnv sets fn to return "KEY=value" variables passed to the
+// process started by an upgrade, such as the configuration in effect so the
+// new process doesn't read files that changed since. The new process reads
+// them with UpgradeEnv. fn is called for each upgrade, and the variables are
+// set in this process's environment only while the new one is started.
+func WithUpgradeEnv(fn func() []string) Option {
+	return func(s *Server) {
+		s.upgradeEnv = fn
+	}
+}
+
+// WithWaitForLock makes a process whose PID file names another running
+// instance wait up to d for it to exit before failing, such as when a restart
+// overlaps the old instance's shutdown. The listeners are bound only after.
+func WithWaitForLock(d time.Duration) Option {
+	return func(s *Server) {
+		s.lockWait = d
+	}
+}
+
+// WithForceExitAfter exits the process with ExitWatchdog if Serve hasn't
+// returned d after the shutdown started, logging the stack of every
+// goroutine, so a Shutdown or Close that hangs can't keep it running until it
+// is killed. It is a last resort and should allow more than
+// WithShutdownDeadline, as no hooks run and the PID file is left behind.
+func WithForceExitAfter(d time.Duration) Option {
+	return func(s *Server) {
+		s.forceExitAfter = d
+	}
+}
+
+// WithProbePropagationDelay keeps serving for d after the health check starts
+// failing, so readiness probes notice before the listeners close, such as one
+// and a half times the Kubernetes probe period. The shutdown runs: health
+// check fails, pre-shutdown hooks, d, WithDrainDelay, then the servers stop
+// accepting connections and the WithBaseContext context is cancelled. Both
+// waits count against WithShutdownDeadline.
+func WithProbePropagationDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.probeDelay = d
+	}
+}
+
+// WithRefuseWhilePaused resets connections that arrive while Pause is in
+// effect, so clients fail fast instead of waiting in the backlog until Resume
+func WithRefuseWhilePaused(enabled bool) Option {
+	return func(s *Server) {
+		s.refuseWhilePaused = enabled
+	}
+}
+
+// WithErrGroupWorkers runs the WithWorkers functions with errgroup semantics
+// instead of as separate actors. They share one context, which the first
+// error cancels for all of them while shutting the servers down, and Serve
+// returns that error. A shutdown cancels the shared context in the phase of
+// priority 0. Otherwise each worker has a context of its own cancelled only
+// by the shutdown, and its error is returned alongside the others'.
+func WithErrGroupWorkers(enabled bool) Option {
+	return func(s *Server) {
+		s.errGroupWorkers = enabled
+	}
+}
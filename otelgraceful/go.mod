@@ -0,0 +1,13 @@
+module github.com/codechimp-io/graceful/otelgraceful
+
+go 1.14
+
+require (
+	github.com/codechimp-io/graceful v0.0.0-20261014054804-794322e7ef25
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+)
+
+// Builds within this repository use the parent module as checked out. Modules
+// depending on this one ignore the replace and use the version required above.
+replace github.com/codechimp-io/graceful => ../
@@ -0,0 +1,97 @@
+// Package otelgraceful traces the graceful shutdown and upgrade lifecycle
+// with OpenTelemetry. It lives in its own module so servers that don't trace
+// don't depend on OpenTelemetry.
+package otelgraceful
+
+import (
+	"context"
+	"sync"
+
+	"github.com/codechimp-io/graceful"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/codechimp-io/graceful/otelgraceful"
+
+// WithTracerProvider records the drain and upgrade phases as graceful.shutdown
+// and graceful.upgrade spans from tp. A nil tp leaves the server untraced.
+func WithTracerProvider(tp trace.TracerProvider) graceful.Option {
+	if tp == nil {
+		return func(*graceful.Server) {}
+	}
+
+	t := &tracer{tracer: tp.Tracer(instrumentationName)}
+
+	return graceful.WithEventHandler(t.handle)
+}
+
+// tracer turns lifecycle events into spans
+type tracer struct {
+	tracer trace.Tracer
+
+	mu       sync.Mutex
+	shutdown trace.Span
+	upgrade  trace.Span
+}
+
+// handle starts and ends spans for the events it is given
+func (t *tracer) handle(ev graceful.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch ev.Type {
+	case graceful.EventShutdownStarted:
+		_, t.shutdown = t.tracer.Start(context.Background(), "graceful.shutdown",
+			trace.WithTimestamp(ev.Time),
+			trace.WithAttributes(
+				attribute.Int("graceful.pid", ev.PID),
+				attribute.Int("graceful.connections", ev.Conns),
+			),
+		)
+
+	case graceful.EventShutdownComplete:
+		if t.shutdown == nil {
+			return
+		}
+
+		t.shutdown.SetAttributes(attribute.Int("graceful.force_closed", ev.ForceClosed))
+		end(t.shutdown, ev)
+		t.shutdown = nil
+
+	case graceful.EventUpgradeStarted:
+		_, t.upgrade = t.tracer.Start(context.Background(), "graceful.upgrade",
+			trace.WithTimestamp(ev.Time),
+			trace.WithAttributes(attribute.Int("graceful.pid", ev.PID)),
+		)
+
+	case graceful.EventUpgradeComplete, graceful.EventUpgradeFailed:
+		if t.upgrade == nil {
+			return
+		}
+
+		end(t.upgrade, ev)
+		t.upgrade = nil
+
+	case graceful.EventError:
+		// Errors during a drain belong to its span
+		if t.shutdown != nil && ev.Err != nil {
+			t.shutdown.RecordError(ev.Err, trace.WithTimestamp(ev.Time))
+		}
+	}
+}
+
+// end records the outcome of ev on span and ends it
+func end(span trace.Span, ev graceful.Event) {
+	span.SetAttributes(attribute.Int64("graceful.duration_ms", ev.Duration.Milliseconds()))
+
+	if ev.Err != nil {
+		span.RecordError(ev.Err, trace.WithTimestamp(ev.Time))
+		span.SetStatus(codes.Error, ev.Err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	span.End(trace.WithTimestamp(ev.Time))
+}
@@ -0,0 +1,107 @@
+package graceful
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/oklog/run"
+)
+
+// PanicError is returned by Serve when one of its actors panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("graceful: panic: %v", e.Value)
+}
+
+// runGroup is a run.Group that turns panics in its actors into errors, so the
+// rest of the group still shuts down and releases the upgrader
+type runGroup struct {
+	run.Group
+	s *Server
+	// interruptErr is the first panic in an interrupt. The interrupts run
+	// one after another on the goroutine calling Run.
+	interruptErr error
+
+	mu       sync.Mutex
+	outcomes []ActorOutcome
+	first    int
+}
+
+// Add adds an actor named name whose panics are returned as a *PanicError,
+// recording what it returned for the RunReport. A panic in interrupt doesn't
+// stop the interrupts after it from running.
+func (g *runGroup) Add(name string, execute func() error, interrupt func(error)) {
+	i := len(g.outcomes)
+	g.outcomes = append(g.outcomes, ActorOutcome{Name: name})
+
+	execute = g.s.recoverPanic(execute)
+	g.Group.Add(func() error {
+		err := execute()
+
+		g.mu.Lock()
+		g.outcomes[i].Err = err
+		first := g.first == 0
+		if first {
+			g.first = i + 1
+			g.outcomes[i].First = true
+		}
+		g.mu.Unlock()
+
+		// Whatever returned first without naming itself is the cause, set
+		// before the interrupts start the drain
+		if first {
+			cause := CauseActorExit
+			if err != nil {
+				cause = CauseError
+			}
+			g.s.setShutdownCause(cause)
+		}
+
+		return err
+	}, func(e error) {
+		err := g.s.recoverPanic(func() error {
+			interrupt(e)
+			return nil
+		})()
+		if err != nil && g.interruptErr == nil {
+			g.interruptErr = err
+		}
+	})
+}
+
+// Run runs the group, returning the error of the first actor to return
+// joined with the first panic in an interrupt
+func (g *runGroup) Run() error {
+	err := g.Group.Run()
+
+	return joinErrors(err, g.interruptErr)
+}
+
+// report returns the outcomes of the actors once Run has returned
+func (g *runGroup) report() RunReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return RunReport{Actors: append([]ActorOutcome(nil), g.outcomes...)}
+}
+
+// recoverPanic wraps execute to recover from a panic and return it as an error
+func (s *Server) recoverPanic(execute func() error) func() error {
+	return func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				perr := &PanicError{Value: r, Stack: debug.Stack()}
+				s.logger.Errorf("Recovered from panic, shutting down: %v\n%s", r, perr.Stack)
+				s.emit(Event{Type: EventError, Err: perr})
+				err = perr
+			}
+		}()
+
+		return execute()
+	}
+}
@@ -0,0 +1,117 @@
+package graceful
+
+import (
+	"net"
+	"sync"
+)
+
+// Pause stops the servers accepting connections until Resume, without
+// shutting down: connections already accepted keep being served and the
+// health check still passes. New connections wait in the kernel backlog, or
+// are reset with WithRefuseWhilePaused. A shutdown while paused drains as
+// usual.
+func (s *Server) Pause() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if s.resumeC != nil {
+		return
+	}
+	s.resumeC = make(chan struct{})
+
+	s.logger.Infof("Paused accepting connections")
+}
+
+// Resume starts accepting connections again after Pause
+func (s *Server) Resume() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if s.resumeC == nil {
+		return
+	}
+	close(s.resumeC)
+	s.resumeC = nil
+
+	s.logger.Infof("Resumed accepting connections")
+}
+
+// Paused reports whether Pause is in effect
+func (s *Server) Paused() bool {
+	return s.paused() != nil
+}
+
+// paused returns the channel closed by the next Resume, or nil when not paused
+func (s *Server) paused() chan struct{} {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	return s.resumeC
+}
+
+// pauseListener holds back Accept while the Server is paused
+type pauseListener struct {
+	net.Listener
+	s *Server
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPauseListener(ln net.Listener, s *Server) *pauseListener {
+	return &pauseListener{Listener: ln, s: s, closed: make(chan struct{})}
+}
+
+func (ln *pauseListener) Accept() (net.Conn, error) {
+	for {
+		if !ln.s.refuseWhilePaused {
+			ln.wait()
+		}
+
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return c, err
+		}
+
+		if ln.s.paused() == nil {
+			return c, nil
+		}
+
+		if !ln.s.refuseWhilePaused {
+			// Accepted as the pause began; serve it once resumed
+			if ln.wait() {
+				return c, nil
+			}
+			_ = c.Close()
+			continue
+		}
+
+		// Reset rather than close, so clients fail fast and retry elsewhere
+		if tc, ok := c.(*net.TCPConn); ok {
+			_ = tc.SetLinger(0)
+		}
+		_ = c.Close()
+	}
+}
+
+// wait blocks while paused. It returns false if the listener was closed,
+// after which Accept returns its usual error.
+func (ln *pauseListener) wait() bool {
+	resumed := ln.s.paused()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ln.closed:
+		return false
+	}
+}
+
+func (ln *pauseListener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.closed) })
+
+	return ln.Listener.Close()
+}
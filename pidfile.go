@@ -0,0 +1,115 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// preparePIDFile creates the PID file's directory if configured and checks it
+// is writable, so a bad path fails before the upgrader is created
+func (s *Server) preparePIDFile() error {
+	if s.pidfile == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(s.pidfile)
+
+	if s.pidDirMode != 0 {
+		err := os.MkdirAll(dir, s.pidDirMode)
+		if err != nil {
+			return fmt.Errorf("graceful: creating PID file directory: %w", err)
+		}
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(s.pidfile))
+	if err != nil {
+		return fmt.Errorf("graceful: PID file directory %s is not writable: %w", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return nil
+}
+
+// chmodPIDFile applies the configured mode to the PID file written by the
+// upgrader
+func (s *Server) chmodPIDFile() {
+	if s.pidfile == "" || s.pidFileMode == 0 || !s.upgrades {
+		return
+	}
+
+	err := os.Chmod(s.pidfile, s.pidFileMode)
+	if err != nil {
+		s.logger.Errorf("Setting PID file mode failed: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+	}
+}
+
+// readPIDFile returns the PID in the PID file, or 0 if there is none
+func (s *Server) readPIDFile() int {
+	if s.pidfile == "" {
+		return 0
+	}
+
+	b, err := ioutil.ReadFile(s.pidfile)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+// checkRunningInstance fails if the PID file names another running process,
+// which means a second instance was started rather than an upgrade. With
+// WithWaitForLock it first waits that long for the process to exit, as when a
+// restart overlaps the old instance's shutdown.
+func (s *Server) checkRunningInstance() error {
+	pid := s.readPIDFile()
+	if pid == 0 || pid == os.Getpid() || !processAlive(pid) {
+		return nil
+	}
+
+	if s.lockWait > 0 {
+		s.logger.Infof("Waiting up to %s for the instance with PID %d to exit", s.lockWait, pid)
+
+		deadline := s.clock.Now().Add(s.lockWait)
+		for processAlive(pid) && s.clock.Now().Before(deadline) {
+			sleepContext(context.Background(), s.clock, 100*time.Millisecond)
+		}
+		if !processAlive(pid) {
+			return nil
+		}
+	}
+
+	return withKind(ErrBind, fmt.Errorf("graceful: another instance is already running, PID %d in %s", pid, s.pidfile))
+}
+
+// removePIDFile removes the PID file on final exit if it still names this
+// process, so monitoring doesn't find a stale PID. After an upgrade it names
+// the new process and is left alone.
+func (s *Server) removePIDFile() {
+	if s.pidfile == "" || !s.upgrades {
+		return
+	}
+
+	if s.readPIDFile() != os.Getpid() {
+		return
+	}
+
+	err := os.Remove(s.pidfile)
+	if err != nil {
+		s.logger.Errorf("Removing PID file failed: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+	}
+}
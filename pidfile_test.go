@@ -0,0 +1,56 @@
+package graceful
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestBindFailureLeavesNoPIDFile serves with the default upgrader in a child
+// process of the test binary, as tableflip allows a single upgrader per
+// process and the test can run more than once
+func TestBindFailureLeavesNoPIDFile(t *testing.T) {
+	if pidfile := os.Getenv("GRACEFUL_TEST_PIDFILE"); pidfile != "" {
+		bindFailure(t, pidfile)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "test.pid")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBindFailureLeavesNoPIDFile$", "-test.v")
+	cmd.Env = append(os.Environ(), "GRACEFUL_TEST_PIDFILE="+pidfile)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("serving in a child process: %v\n%s", err, out)
+	}
+
+	if _, err := os.Stat(pidfile); !os.IsNotExist(err) {
+		t.Errorf("PID file %s remains after a failed bind: %v", pidfile, err)
+	}
+}
+
+// bindFailure serves with a listener that fails to bind
+func bindFailure(t *testing.T, pidfile string) {
+	s := newTestServer(t,
+		WithUpgrades(true),
+		WithPIDFile(pidfile),
+		WithListenFunc(func(network, addr string) (net.Listener, error) {
+			return nil, errors.New("address already in use")
+		}),
+	)
+
+	err := s.Serve(&http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()})
+	if !errors.Is(err, ErrBind) {
+		t.Fatalf("Serve returned %v, want ErrBind", err)
+	}
+}
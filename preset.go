@@ -0,0 +1,34 @@
+package graceful
+
+import "time"
+
+// Default returns options suited to running in Kubernetes, where the pod is
+// replaced rather than upgraded in place and SIGTERM starts a grace period of
+// 30 seconds by default:
+//
+//   - WithUpgrades(false)
+//   - WithShutdownSignals with SIGINT and SIGTERM
+//   - WithHealthPath("/healthz"), failing once shutdown starts
+//   - WithDrainDelay(5 * time.Second), so endpoints stop routing to the pod
+//     before it stops accepting connections
+//   - WithDisableKeepAlivesOnDrain(true)
+//   - WithShutdownTimeout(20 * time.Second) for each server
+//   - WithShutdownDeadline(25 * time.Second) for the whole shutdown, leaving
+//     headroom before the kubelet kills the process
+//   - WithSystemdNotify(false)
+//
+// Options are applied in order, so later ones override these:
+//
+//	s := graceful.New(append(graceful.Default(), graceful.WithDrainDelay(10*time.Second))...)
+func Default() []Option {
+	return []Option{
+		WithUpgrades(false),
+		WithShutdownSignals(defaultShutdownSignals...),
+		WithHealthPath("/healthz"),
+		WithDrainDelay(5 * time.Second),
+		WithDisableKeepAlivesOnDrain(true),
+		WithShutdownTimeout(20 * time.Second),
+		WithShutdownDeadline(25 * time.Second),
+		WithSystemdNotify(false),
+	}
+}
@@ -0,0 +1,55 @@
+package graceful
+
+import (
+	"fmt"
+	"time"
+)
+
+// pressureInterval is how often the memory pressure is read. The kernel
+// updates its ten second average every two seconds.
+const pressureInterval = time.Second
+
+// addMemoryPressureShutdown adds an actor that fails once the memory pressure
+// of the cgroup reaches the threshold, shutting the group down. Without
+// cgroup v2 pressure information it adds nothing.
+func (s *Server) addMemoryPressureShutdown(group *runGroup) {
+	path := s.pressurePath()
+	if path == "" {
+		s.logger.Infof("Cgroup v2 memory pressure isn't available, not watching it")
+		return
+	}
+
+	cancel := make(chan struct{})
+
+	group.Add(
+		"memory pressure",
+		func() error {
+			ticker := s.clock.NewTicker(pressureInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C():
+				case <-cancel:
+					return nil
+				}
+
+				pressure, err := readMemoryPressure(path)
+				if err != nil {
+					s.logger.Warnf("Reading memory pressure failed: %v", err)
+					continue
+				}
+				if pressure < s.memoryPressure {
+					continue
+				}
+
+				s.setShutdownCause(CauseMemoryPressure)
+				s.logger.Errorf("Memory pressure of %.1f%% reached %.1f%%, exiting gracefully...", pressure, s.memoryPressure)
+				return fmt.Errorf("graceful: memory pressure of %.1f%% reached the threshold of %.1f%%", pressure, s.memoryPressure)
+			}
+		},
+		func(e error) {
+			close(cancel)
+		},
+	)
+}
@@ -0,0 +1,72 @@
+package graceful
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// memoryPressurePath returns the memory.pressure file of the cgroup v2 this
+// process is in, or "" if there is none
+func memoryPressurePath() string {
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+
+	// The cgroup v2 hierarchy is the line with ID 0 and no controllers
+	var cgroup string
+	found := false
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			cgroup, found = strings.TrimPrefix(line, "0::"), true
+			break
+		}
+	}
+	if !found {
+		return ""
+	}
+
+	// Hybrid setups mount cgroup v2 on unified
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		path := filepath.Join(root, cgroup, "memory.pressure")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// readMemoryPressure returns the share of the last ten seconds, in percent,
+// in which some task of the cgroup was stalled waiting for memory
+func readMemoryPressure(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "avg10=") {
+				return strconv.ParseFloat(strings.TrimPrefix(field, "avg10="), 64)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("no avg10 in %s", path)
+}
@@ -0,0 +1,39 @@
+package graceful
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryPressureCause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "memory.pressure")
+	err = ioutil.WriteFile(path, []byte("some avg10=95.00 avg60=40.00 avg300=10.00 total=123456\n"+
+		"full avg10=50.00 avg60=20.00 avg300=5.00 total=65432\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, WithMemoryPressureShutdown(90))
+	s.pressurePath = func() string { return path }
+	ln := listen(t)
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	clock, errc := serveClock(s, func() error { return s.ServeListener(ln, srv) })
+
+	<-s.ready
+	clock.BlockUntil(1)
+	clock.Advance(pressureInterval)
+
+	err = waitCause(t, s, errc, CauseMemoryPressure)
+	if err == nil {
+		t.Error("Serve returned nil after reaching the memory pressure threshold")
+	}
+}
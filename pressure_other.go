@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package graceful
+
+import "errors"
+
+// memoryPressurePath returns "", as only Linux has cgroup v2
+func memoryPressurePath() string {
+	return ""
+}
+
+func readMemoryPressure(path string) (float64, error) {
+	return 0, errors.New("memory pressure is only available on Linux")
+}
@@ -0,0 +1,12 @@
+module github.com/codechimp-io/graceful/promgraceful
+
+go 1.14
+
+require (
+	github.com/codechimp-io/graceful v0.0.0-20261014054804-794322e7ef25
+	github.com/prometheus/client_golang v1.11.0
+)
+
+// Builds within this repository use the parent module as checked out. Modules
+// depending on this one ignore the replace and use the version required above.
+replace github.com/codechimp-io/graceful => ../
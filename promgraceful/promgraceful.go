@@ -0,0 +1,89 @@
+// Package promgraceful exports the lifecycle state and counters of a
+// graceful.Server as Prometheus metrics. It lives in its own module so servers that don't use
+// Prometheus don't depend on it.
+package promgraceful
+
+import (
+	"github.com/codechimp-io/graceful"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "graceful"
+
+// states are the lifecycle states reported by the state gauge
+var states = []graceful.State{
+	graceful.StateStarting,
+	graceful.StateServing,
+	graceful.StateDraining,
+	graceful.StateStopped,
+}
+
+// Collector is a prometheus.Collector for a graceful.Server
+type Collector struct {
+	s *graceful.Server
+
+	state        *prometheus.Desc
+	ready        *prometheus.Desc
+	shuttingDown *prometheus.Desc
+	connections  *prometheus.Desc
+	requests     *prometheus.Desc
+	upgrades     *prometheus.Desc
+	lastShutdown *prometheus.Desc
+}
+
+// NewCollector returns a Collector reading the counters of s on each scrape
+func NewCollector(s *graceful.Server) *Collector {
+	return &Collector{
+		s: s,
+
+		state: prometheus.NewDesc(namespace+"_state",
+			"Lifecycle state, 1 for the current state and 0 for the others.", []string{"state"}, nil),
+		ready: prometheus.NewDesc(namespace+"_ready",
+			"Whether the servers are listening and not shutting down.", nil, nil),
+		shuttingDown: prometheus.NewDesc(namespace+"_shutting_down",
+			"Whether shutdown has begun.", nil, nil),
+		connections: prometheus.NewDesc(namespace+"_connections",
+			"Number of open connections.", nil, nil),
+		requests: prometheus.NewDesc(namespace+"_requests_total",
+			"Number of requests started on HTTP/1 connections.", nil, nil),
+		upgrades: prometheus.NewDesc(namespace+"_upgrades_total",
+			"Number of upgrades this process has completed.", nil, nil),
+		lastShutdown: prometheus.NewDesc(namespace+"_last_shutdown_seconds",
+			"Duration of the last drain.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.ready
+	ch <- c.shuttingDown
+	ch <- c.connections
+	ch <- c.requests
+	ch <- c.upgrades
+	ch <- c.lastShutdown
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.s.Stats()
+
+	current := c.s.State()
+	for _, st := range states {
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, boolValue(st == current), st.String())
+	}
+	ch <- prometheus.MustNewConstMetric(c.ready, prometheus.GaugeValue, boolValue(stats.Ready))
+	ch <- prometheus.MustNewConstMetric(c.shuttingDown, prometheus.GaugeValue, boolValue(stats.ShuttingDown))
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.Connections))
+	ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(stats.Requests))
+	ch <- prometheus.MustNewConstMetric(c.upgrades, prometheus.CounterValue, float64(stats.Upgrades))
+	ch <- prometheus.MustNewConstMetric(c.lastShutdown, prometheus.GaugeValue, stats.LastShutdown.Seconds())
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,307 @@
+package graceful
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a client may take to send its PROXY header
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts every version 2 PROXY header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("graceful: invalid PROXY protocol header")
+
+// proxyListener decodes the PROXY protocol header of every accepted
+// connection before Accept returns it, each in a goroutine of its own so a
+// slow client doesn't hold up Accept or the other clients. The header is read
+// before net/http or a ConnState hook sees the connection, so its addresses
+// are there from the start and no deadline of theirs is overridden.
+type proxyListener struct {
+	net.Listener
+	conns chan net.Conn
+	errs  chan error
+	// closing is closed by Close, done once the accept loop has returned with
+	// err
+	closing   chan struct{}
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+	mu        sync.Mutex
+	// pending are the connections whose header is being read
+	pending map[net.Conn]struct{}
+}
+
+func newProxyListener(ln net.Listener) *proxyListener {
+	p := &proxyListener{
+		Listener: ln,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		pending:  make(map[net.Conn]struct{}),
+	}
+	go p.acceptLoop()
+
+	return p
+}
+
+func (ln *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case err := <-ln.errs:
+		return nil, err
+	case <-ln.done:
+		return nil, ln.err
+	}
+}
+
+// Close closes the listener and the connections whose header is being read
+func (ln *proxyListener) Close() error {
+	err := ln.Listener.Close()
+	ln.closeOnce.Do(func() {
+		close(ln.closing)
+	})
+
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	for c := range ln.pending {
+		_ = c.Close()
+	}
+
+	return err
+}
+
+// acceptLoop accepts connections and reads their headers until the listener
+// fails. Temporary errors are handed to Accept, whose caller backs off.
+func (ln *proxyListener) acceptLoop() {
+	defer close(ln.done)
+
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				select {
+				case ln.errs <- err:
+					continue
+				case <-ln.closing:
+				}
+			}
+
+			ln.err = err
+			return
+		}
+
+		// Close closes the pending connections after closing, so c is either
+		// closed here or by Close
+		ln.mu.Lock()
+		select {
+		case <-ln.closing:
+			_ = c.Close()
+		default:
+			ln.pending[c] = struct{}{}
+			go ln.readHeader(c)
+		}
+		ln.mu.Unlock()
+	}
+}
+
+// readHeader reads the header of c and hands it to Accept
+func (ln *proxyListener) readHeader(c net.Conn) {
+	r := bufio.NewReader(c)
+	_ = c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	remote, local, err := readProxyHeader(r)
+	_ = c.SetReadDeadline(time.Time{})
+
+	ln.mu.Lock()
+	delete(ln.pending, c)
+	ln.mu.Unlock()
+
+	if err != nil {
+		// The spec requires dropping connections with a bad header
+		_ = c.Close()
+		return
+	}
+
+	select {
+	case ln.conns <- &proxyConn{Conn: c, r: r, remote: remote, local: local}:
+	case <-ln.closing:
+		_ = c.Close()
+	}
+}
+
+// proxyConn is a connection whose PROXY header has been read. RemoteAddr and
+// LocalAddr are the addresses from the header, or the real ones for LOCAL and
+// UNKNOWN connections.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads a version 1 or 2 PROXY header from r. The addresses
+// are nil for LOCAL and UNKNOWN connections, which keep the real ones.
+func readProxyHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+
+	prefix, err := r.Peek(6)
+	if err == nil && string(prefix) == "PROXY " {
+		return readProxyV1(r)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+
+	return nil, nil, fmt.Errorf("%w: missing", errProxyHeader)
+}
+
+// readProxyV1 reads a header like "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	// The longest valid version 1 header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: unterminated", errProxyHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", errProxyHeader, line)
+	}
+
+	remote, err := parseProxyAddr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	local, err := parseProxyAddr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return remote, local, nil
+}
+
+// parseProxyAddr parses one address of a version 1 header
+func parseProxyAddr(proto, host, port string) (net.Addr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("%w: bad address %q", errProxyHeader, host)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: bad port %q", errProxyHeader, port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyV2 reads a binary version 2 header
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 16)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: version %d", errProxyHeader, hdr[12]>>4)
+	}
+
+	cmd := hdr[12] & 0xf
+	if cmd > 1 {
+		return nil, nil, fmt.Errorf("%w: command %d", errProxyHeader, cmd)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections come from the proxy itself, such as health checks
+	if cmd == 0 {
+		return nil, nil, nil
+	}
+
+	family, proto := hdr[13]>>4, hdr[13]&0xf
+	if proto != 1 && proto != 2 {
+		// Unspecified or unknown transports keep the real addresses
+		return nil, nil, nil
+	}
+
+	var size int
+	switch family {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// AF_UNIX and unspecified families keep the real addresses
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: short address block", errProxyHeader)
+	}
+
+	src := net.IP(payload[:size])
+	dst := net.IP(payload[size : 2*size])
+	sport := int(binary.BigEndian.Uint16(payload[2*size:]))
+	dport := int(binary.BigEndian.Uint16(payload[2*size+2:]))
+
+	if proto == 2 {
+		return &net.UDPAddr{IP: src, Port: sport}, &net.UDPAddr{IP: dst, Port: dport}, nil
+	}
+
+	return &net.TCPAddr{IP: src, Port: sport}, &net.TCPAddr{IP: dst, Port: dport}, nil
+}
@@ -0,0 +1,212 @@
+package graceful
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2 returns a version 2 header with the command, family and transport
+// byte and address block
+func proxyV2(cmd, family byte, addrs []byte) []byte {
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(addrs)))
+
+	return append(hdr, addrs...)
+}
+
+// proxyV2Addrs returns the address block of src and dst
+func proxyV2Addrs(src, dst net.IP, sport, dport uint16) []byte {
+	b := append(append([]byte(nil), src...), dst...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-4:], sport)
+	binary.BigEndian.PutUint16(b[len(b)-2:], dport)
+
+	return b
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := proxyV2Addrs(net.IPv4(1, 2, 3, 4).To4(), net.IPv4(5, 6, 7, 8).To4(), 1234, 80)
+	v6 := proxyV2Addrs(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 1234, 443)
+
+	tests := []struct {
+		name          string
+		header        string
+		remote, local string
+		wantErr       bool
+	}{
+		{name: "v1 TCP4", header: "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", remote: "1.2.3.4:1234", local: "5.6.7.8:80"},
+		{name: "v1 TCP6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n", remote: "[2001:db8::1]:1234", local: "[2001:db8::2]:443"},
+		{name: "v1 UNKNOWN", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 UNKNOWN with addresses", header: "PROXY UNKNOWN 1.2.3.4 5.6.7.8 1234 80\r\n"},
+		{name: "v1 missing CRLF", header: "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\n", wantErr: true},
+		{name: "v1 unterminated", header: "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80", wantErr: true},
+		{name: "v1 over 107 bytes", header: "PROXY TCP6 " + strings.Repeat("f", 100) + " ::1 1 2\r\n", wantErr: true},
+		{name: "v1 port out of range", header: "PROXY TCP4 1.2.3.4 5.6.7.8 65536 80\r\n", wantErr: true},
+		{name: "v1 port with leading zero", header: "PROXY TCP4 1.2.3.4 5.6.7.8 1234 080\r\n", wantErr: true},
+		{name: "v1 port not a number", header: "PROXY TCP4 1.2.3.4 5.6.7.8 http 80\r\n", wantErr: true},
+		{name: "v1 family mismatch", header: "PROXY TCP4 2001:db8::1 5.6.7.8 1234 80\r\n", wantErr: true},
+		{name: "v1 missing field", header: "PROXY TCP4 1.2.3.4 5.6.7.8 1234\r\n", wantErr: true},
+		{name: "v1 unknown protocol", header: "PROXY UDP4 1.2.3.4 5.6.7.8 1234 80\r\n", wantErr: true},
+		{name: "no header", header: "GET / HTTP/1.1\r\n\r\n", wantErr: true},
+		{name: "empty", header: "", wantErr: true},
+		{name: "v2 TCP over IPv4", header: string(proxyV2(1, 0x11, v4)), remote: "1.2.3.4:1234", local: "5.6.7.8:80"},
+		{name: "v2 TCP over IPv6", header: string(proxyV2(1, 0x21, v6)), remote: "[2001:db8::1]:1234", local: "[2001:db8::2]:443"},
+		{name: "v2 UDP over IPv4", header: string(proxyV2(1, 0x12, v4)), remote: "1.2.3.4:1234", local: "5.6.7.8:80"},
+		{name: "v2 LOCAL", header: string(proxyV2(0, 0x11, v4))},
+		{name: "v2 LOCAL without addresses", header: string(proxyV2(0, 0, nil))},
+		{name: "v2 unix family", header: string(proxyV2(1, 0x31, make([]byte, 216)))},
+		{name: "v2 unknown family", header: string(proxyV2(1, 0x41, v4))},
+		{name: "v2 bad signature", header: "\r\n\r\n\x00\r\nQUIX\n" + string(proxyV2(1, 0x11, v4)[12:]), wantErr: true},
+		{name: "v2 bad version", header: string(append(proxyV2Signature[:12:12], 0x11, 0x11, 0, 12)) + string(v4), wantErr: true},
+		{name: "v2 unknown command", header: string(proxyV2(2, 0x11, v4)), wantErr: true},
+		{name: "v2 short address block", header: string(proxyV2(1, 0x11, v4[:8])), wantErr: true},
+		{name: "v2 truncated address block", header: string(proxyV2(1, 0x11, v4)[:20]), wantErr: true},
+		{name: "v2 truncated header", header: string(proxyV2Signature), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote, local, err := readProxyHeader(bufio.NewReader(strings.NewReader(tt.header)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, %v, want an error", remote, local)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := addrString(remote); got != tt.remote {
+				t.Errorf("remote = %q, want %q", got, tt.remote)
+			}
+			if got := addrString(local); got != tt.local {
+				t.Errorf("local = %q, want %q", got, tt.local)
+			}
+		})
+	}
+}
+
+// addrString returns a.String(), or "" for nil
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+
+	return a.String()
+}
+
+func TestReadProxyHeaderKeepsPayload(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\nGET / HTTP/1.1\r\n"))
+	_, _, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rest, _ := ioutil.ReadAll(r)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("read past the header: %q left", rest)
+	}
+}
+
+// remoteAddrServer serves the request's RemoteAddr
+var remoteAddrServer = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, r.RemoteAddr)
+})
+
+func TestProxyProtocolDropsBadHeader(t *testing.T) {
+	s := newTestServer(t, WithProxyProtocol(true))
+	ln := listen(t)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, &http.Server{Handler: remoteAddrServer}) }()
+	<-s.ready
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(c, "PROXY TCP4 1.2.3.4 5.6.7.8 99999 80\r\nGET / HTTP/1.0\r\n\r\n")
+	b, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil || len(b) > 0 {
+		t.Errorf("bad header got %q, %v, want the connection closed", b, err)
+	}
+
+	// A client sending a header after the bad one is still served
+	c, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(c, "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nGET / HTTP/1.0\r\n\r\n")
+	b, err = ioutil.ReadAll(c)
+	c.Close()
+	if err != nil || !bytes.HasSuffix(b, []byte("\r\n\r\n1.2.3.4:1234")) {
+		t.Errorf("good header got %q, %v, want the header's address", b, err)
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve returned %v, want nil", err)
+	}
+}
+
+func TestProxyProtocolSlowClientDoesntBlockAccept(t *testing.T) {
+	var hookAddrs []string
+	s := newTestServer(t,
+		WithProxyProtocol(true),
+		WithConnStateHook(func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				hookAddrs = append(hookAddrs, c.RemoteAddr().String())
+			}
+		}),
+	)
+	ln := listen(t)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, &http.Server{Handler: remoteAddrServer}) }()
+	<-s.ready
+
+	// A client that never sends its header
+	slow, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetDeadline(time.Now().Add(proxyHeaderTimeout / 2))
+	fmt.Fprint(c, "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nGET / HTTP/1.0\r\n\r\n")
+	b, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil || !bytes.HasSuffix(b, []byte("1.2.3.4:1234")) {
+		t.Errorf("got %q, %v behind a slow client, want the header's address", b, err)
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	<-errc
+
+	if len(hookAddrs) != 1 || hookAddrs[0] != "1.2.3.4:1234" {
+		t.Errorf("ConnState hook saw %v, want the header's address", hookAddrs)
+	}
+}
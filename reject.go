@@ -0,0 +1,74 @@
+package graceful
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// acceptedKey is the context key for the time a connection was accepted
+type acceptedKey struct{}
+
+// drainExempt reports whether r is on a path WithDrainExemptPaths keeps
+// serving during the drain
+func (s *Server) drainExempt(r *http.Request) bool {
+	for _, prefix := range s.drainExemptPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectDuringDrain makes server answer requests on connections accepted
+// after shutdown began with the drain response, while requests on earlier
+// connections are served as usual
+func (s *Server) rejectDuringDrain(server *http.Server) {
+	connContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+
+		return context.WithValue(ctx, acceptedKey{}, s.clock.Now().UnixNano())
+	}
+
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := atomic.LoadInt64(&s.drainStarted)
+		accepted, _ := r.Context().Value(acceptedKey{}).(int64)
+
+		if started == 0 || accepted < started || s.drainExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := s.drainRetryAfter
+		if retryAfter == 0 && s.maintenancePage != nil {
+			retryAfter = time.Second
+		}
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		}
+		w.Header().Set("Connection", "close")
+
+		if s.maintenancePage == nil {
+			http.Error(w, http.StatusText(s.drainStatus), s.drainStatus)
+			return
+		}
+
+		w.Header().Set("Content-Type", http.DetectContentType(s.maintenancePage))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(s.drainStatus)
+		_, _ = w.Write(s.maintenancePage)
+	})
+}
@@ -0,0 +1,258 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// relistenOverlap is how long a replaced listener keeps accepting alongside
+// the new one, so connections already queued on it are served
+const relistenOverlap = time.Second
+
+var errNotRelistening = errors.New("graceful: listener reload is not enabled")
+
+// ReloadListeners binds every listener again in this process and moves the
+// servers over to the new ones, such as to apply socket options from
+// WithListenFunc without an upgrade. The old listeners keep accepting for a
+// second alongside the new ones and are then closed, while connections
+// accepted from them are served to completion as usual. Upgrades pass on the
+// new listeners. Unix sockets and listeners given to ServeListener are kept
+// as they are.
+//
+// It requires WithListenerReload, and the old and new sockets share the
+// address through SO_REUSEPORT, which a WithListenFunc must set as well.
+func (s *Server) ReloadListeners() error {
+	if !s.listenerReload {
+		return errNotRelistening
+	}
+
+	s.relistenMu.Lock()
+	defer s.relistenMu.Unlock()
+
+	select {
+	case <-s.ready:
+	default:
+		return errors.New("graceful: the servers aren't serving yet")
+	}
+	if s.ShuttingDown() {
+		return errors.New("graceful: shutting down")
+	}
+
+	// The startup context is done by now; retrying a bind gives up once
+	// shutdown begins instead
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.Draining():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var errs []error
+	for _, m := range s.servers {
+		if _, ok := s.provided[m.http]; ok && m.http != nil {
+			continue
+		}
+
+		for i, ln := range m.listeners {
+			sl, ok := ln.(*swapListener)
+			if !ok || i >= len(m.addrs) {
+				continue
+			}
+
+			errs = append(errs, s.relisten(ctx, m.addrs[i], sl))
+		}
+	}
+
+	return joinErrors(errs...)
+}
+
+// relisten binds the address of sl again within ctx and swaps the new
+// listener in
+func (s *Server) relisten(ctx context.Context, addr string, sl *swapListener) error {
+	network, address := splitAddr(addr, s.network)
+	if network == "unix" {
+		return nil
+	}
+
+	// Bind the port actually in use, which differs from the address for port 0
+	bound := sl.Addr().String()
+
+	ln, err := s.createListener(ctx, network, bound)
+	if err != nil {
+		return fmt.Errorf("rebinding [%s]: %w", addr, err)
+	}
+
+	if !s.rebind {
+		tl, ok := ln.(InheritableListener)
+		if !ok {
+			_ = ln.Close()
+			return fmt.Errorf("rebinding [%s]: %T can't be inherited", addr, ln)
+		}
+
+		err := s.upg.AddListener(network, s.fdName(network, address), tl)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("rebinding [%s]: %w", addr, err)
+		}
+	}
+
+	sl.swap(s.wrapListener(ln), ln)
+	s.logger.Infof("Reloaded the listener on [%s]", bound)
+
+	return nil
+}
+
+// acceptResult is a connection accepted by one of a swapListener's listeners
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// swapListener accepts from a listener that can be replaced while serving.
+// The server keeps accepting from the swapListener and never sees the swap.
+type swapListener struct {
+	accepted chan acceptResult
+	clock    clock
+
+	mu       sync.Mutex
+	cur      *swapped
+	retiring []*swapped
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// swapped is a listener of a swapListener
+type swapped struct {
+	ln net.Listener
+	// raw is the socket ln wraps
+	raw net.Listener
+	// retired is set under the swapListener's mu once ln is closed on purpose
+	retired bool
+}
+
+func newSwapListener(ln, raw net.Listener, c clock) *swapListener {
+	sl := &swapListener{
+		accepted: make(chan acceptResult),
+		clock:    c,
+		cur:      &swapped{ln: ln, raw: raw},
+		closed:   make(chan struct{}),
+	}
+	go sl.acceptLoop(sl.cur)
+
+	return sl
+}
+
+// acceptLoop hands the connections accepted from l to Accept, until l is
+// retired or fails
+func (sl *swapListener) acceptLoop(l *swapped) {
+	for {
+		c, err := l.ln.Accept()
+
+		sl.mu.Lock()
+		retired := l.retired
+		sl.mu.Unlock()
+
+		if err != nil && retired {
+			return
+		}
+
+		select {
+		case sl.accepted <- acceptResult{conn: c, err: err}:
+		case <-sl.closed:
+			if c != nil {
+				_ = c.Close()
+			}
+			return
+		}
+
+		var ne net.Error
+		if err != nil && !(errors.As(err, &ne) && ne.Temporary()) {
+			return
+		}
+	}
+}
+
+func (sl *swapListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-sl.accepted:
+		return r.conn, r.err
+	case <-sl.closed:
+		// Accept on the closed listener returns its usual error
+		sl.mu.Lock()
+		cur := sl.cur
+		sl.mu.Unlock()
+
+		return cur.ln.Accept()
+	}
+}
+
+// swap makes ln, wrapping raw, the listener accepted from, retiring the
+// current one once it has accepted alongside ln for relistenOverlap
+func (sl *swapListener) swap(ln, raw net.Listener) {
+	sl.mu.Lock()
+	old := sl.cur
+	sl.cur = &swapped{ln: ln, raw: raw}
+	sl.retiring = append(sl.retiring, old)
+	go sl.acceptLoop(sl.cur)
+	sl.mu.Unlock()
+
+	overlap := sl.clock.NewTimer(relistenOverlap)
+	go func() {
+		<-overlap.C()
+
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+
+		for i, l := range sl.retiring {
+			if l == old {
+				sl.retiring = append(sl.retiring[:i], sl.retiring[i+1:]...)
+				sl.retire(old)
+				break
+			}
+		}
+	}()
+}
+
+// retire closes l, marking it so its accept loop stops quietly. It must be
+// called with mu held.
+func (sl *swapListener) retire(l *swapped) {
+	l.retired = true
+
+	// The upgrader may still hold a duplicate of the socket
+	unlisten(l.raw)
+	_ = l.ln.Close()
+}
+
+func (sl *swapListener) Close() error {
+	var err error
+	sl.closeOnce.Do(func() {
+		close(sl.closed)
+
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+
+		for _, l := range sl.retiring {
+			sl.retire(l)
+		}
+		sl.retiring = nil
+
+		err = sl.cur.ln.Close()
+	})
+
+	return err
+}
+
+func (sl *swapListener) Addr() net.Addr {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	return sl.cur.ln.Addr()
+}
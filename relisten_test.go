@@ -0,0 +1,56 @@
+package graceful
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadListenersAfterStartupTimeout(t *testing.T) {
+	// The first rebind finds the address in use, as when another process
+	// holds it, and succeeds on the retry
+	var inUse int32
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		if atomic.CompareAndSwapInt32(&inUse, 1, 0) {
+			return syscall.EADDRINUSE
+		}
+		return nil
+	}}
+
+	const startupTimeout = 50 * time.Millisecond
+	s := newTestServer(t,
+		WithListenerReload(nil),
+		WithListenConfig(lc),
+		WithBindRetry(1, 10*time.Millisecond),
+		WithStartupTimeout(startupTimeout),
+	)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(srv) }()
+	<-s.ready
+
+	time.Sleep(2 * startupTimeout)
+	atomic.StoreInt32(&inUse, 1)
+
+	err := s.ReloadListeners()
+	if err != nil {
+		t.Fatalf("ReloadListeners after the startup timeout: %v", err)
+	}
+
+	resp, err := http.Get("http://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("request after the reload: %v", err)
+	}
+	resp.Body.Close()
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	<-errc
+}
@@ -0,0 +1,75 @@
+package graceful
+
+import (
+	"os"
+	"time"
+)
+
+// reloadPollInterval is how often the reload file is checked. A change is
+// acted on once the file has stayed the same for a whole interval, so a burst
+// of writes triggers a single upgrade.
+const reloadPollInterval = time.Second
+
+// fileState is what is compared to detect a change of the reload file
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{exists: true, modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// addReloadFile adds an actor that upgrades when the reload file changes
+func (s *Server) addReloadFile(group *runGroup) {
+	cancel := make(chan struct{})
+
+	group.Add(
+		"reload file",
+		func() error {
+			ticker := s.clock.NewTicker(reloadPollInterval)
+			defer ticker.Stop()
+
+			last := statFile(s.reloadFile)
+			pending := false
+
+			for {
+				select {
+				case <-ticker.C():
+				case <-cancel:
+					return nil
+				}
+
+				current := statFile(s.reloadFile)
+				if current != last {
+					last = current
+					pending = true
+					continue
+				}
+
+				if !pending {
+					continue
+				}
+				pending = false
+
+				s.logger.Infof("Reload file %s changed, restarting gracefully...", s.reloadFile)
+				err := s.upgrade()
+
+				// The new process watches the file from now on
+				if err == nil {
+					<-cancel
+					return nil
+				}
+			}
+		},
+		func(e error) {
+			close(cancel)
+		},
+	)
+}
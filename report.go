@@ -0,0 +1,40 @@
+package graceful
+
+// RunReport is how each actor of a Serve ended, for telling what caused a
+// shutdown, such as a failing worker rather than a signal
+type RunReport struct {
+	// Actors are in the order Serve started them
+	Actors []ActorOutcome
+}
+
+// ActorOutcome is how one actor of a Serve ended
+type ActorOutcome struct {
+	// Name describes the actor, such as "HTTP Server [127.0.0.1:8080]",
+	// "shutdown signals" or "worker 2"
+	Name string
+	// Err is the error the actor returned, or that a worker or an actor
+	// classified as not fatal reported while the rest kept running
+	Err error
+	// First is set on the actor whose return shut the others down
+	First bool
+}
+
+// Initiator returns the actor whose return shut the others down, if any
+func (r RunReport) Initiator() (ActorOutcome, bool) {
+	for _, a := range r.Actors {
+		if a.First {
+			return a, true
+		}
+	}
+
+	return ActorOutcome{}, false
+}
+
+// LastRunReport returns how the actors of the last Serve ended. It is empty
+// until Serve's actors have all returned, and complete once Wait returns.
+func (s *Server) LastRunReport() RunReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return RunReport{Actors: append([]ActorOutcome(nil), s.runReport.Actors...)}
+}
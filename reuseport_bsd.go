@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package graceful
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT
+const soReusePort = syscall.SO_REUSEPORT
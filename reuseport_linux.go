@@ -0,0 +1,4 @@
+package graceful
+
+// soReusePort is SO_REUSEPORT, which package syscall doesn't define on Linux
+const soReusePort = 0xf
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package graceful
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePort is unavailable, so rebinding an address still held by the old
+// process waits for WithBindRetry
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// unlisten does nothing, as listeners don't share an address without reusePort
+func unlisten(ln net.Listener) {}
@@ -0,0 +1,46 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package graceful
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePort lets the upgraded process bind the port the old one still holds
+func reusePort(network, address string, c syscall.RawConn) error {
+	// Unix sockets can't share a path
+	if network == "unix" {
+		return nil
+	}
+
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+// unlisten stops ln listening even while a duplicate of its descriptor is
+// open, such as the one an upgrader keeps to pass on, so it stops receiving
+// connections that nothing would accept
+func unlisten(ln net.Listener) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	_ = raw.Control(func(fd uintptr) {
+		_ = syscall.Shutdown(int(fd), syscall.SHUT_RDWR)
+	})
+}
@@ -0,0 +1,46 @@
+package graceful
+
+import "fmt"
+
+// addSelfHealthCheck adds an actor running the self health check every
+// interval, which fails once the check has failed enough times in a row,
+// shutting the group down
+func (s *Server) addSelfHealthCheck(group *runGroup) {
+	cancel := make(chan struct{})
+
+	group.Add(
+		"self health check",
+		func() error {
+			ticker := s.clock.NewTicker(s.selfCheckInterval)
+			defer ticker.Stop()
+
+			var failures int
+			for {
+				select {
+				case <-ticker.C():
+				case <-cancel:
+					return nil
+				}
+
+				err := s.selfCheck()
+				if err == nil {
+					failures = 0
+					continue
+				}
+
+				failures++
+				s.logger.Warnf("Self health check failed (%d/%d): %v", failures, s.selfCheckFailures, err)
+				if failures < s.selfCheckFailures {
+					continue
+				}
+
+				s.setShutdownCause(CauseSelfHealthCheck)
+				s.logger.Errorf("Self health check failed %d times in a row, exiting gracefully...", failures)
+				return fmt.Errorf("graceful: self health check failed %d times in a row: %w", failures, err)
+			}
+		},
+		func(e error) {
+			close(cancel)
+		},
+	)
+}
@@ -0,0 +1,204 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// GracefulServer is a server that can be run and gracefully shut down by Server.
+// *http.Server satisfies it directly.
+type GracefulServer interface {
+	Serve(net.Listener) error
+	Shutdown(context.Context) error
+}
+
+// Register adds a server listening on addr to be run alongside the http
+// servers passed to Serve. It must be called before Serve.
+func (s *Server) Register(addr string, srv GracefulServer) {
+	s.RegisterWithPriority(0, addr, srv)
+}
+
+// RegisterWithPriority registers a server like Register that is shut down in
+// the shutdown phase for priority, see AddWithPriority
+func (s *Server) RegisterWithPriority(priority int, addr string, srv GracefulServer) {
+	s.registered = append(s.registered, registration{priority: priority, addr: addr, srv: srv})
+}
+
+// registration is a server given to Register. It is only set up by Serve, so
+// the clock and ConnState hooks of options applied after it reach it too.
+type registration struct {
+	priority int
+	addr     string
+	srv      GracefulServer
+}
+
+// grpcServer is the subset of *grpc.Server needed to manage it
+type grpcServer interface {
+	Serve(net.Listener) error
+	GracefulStop()
+	Stop()
+}
+
+// grpcAdapter maps Shutdown onto a gRPC server's GracefulStop
+type grpcAdapter struct {
+	grpcServer
+}
+
+// GRPCServer adapts a *grpc.Server to GracefulServer. Shutdown waits for
+// GracefulStop and falls back to Stop when the context is done first.
+func GRPCServer(srv grpcServer) GracefulServer {
+	return grpcAdapter{srv}
+}
+
+func (g grpcAdapter) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// managed is a server run by Server along with its lifecycle state
+type managed struct {
+	// addr describes all addresses for log messages
+	addr  string
+	addrs []string
+	srv   GracefulServer
+	// http is set when srv is an *http.Server
+	http      *http.Server
+	conns     connTracker
+	listeners []net.Listener
+	priority  int
+	// shutdownTimeout overrides the server's shutdown timeout when non-zero
+	shutdownTimeout time.Duration
+	hijacked        hijackTracker
+	// requests are the in-flight requests with WithDrainDiagnostics
+	requests requestTracker
+	// h2c is set when the server also serves HTTP/2 cleartext
+	h2c *h2cState
+}
+
+// newManaged wraps srv, chaining connection tracking onto the ConnState hook
+// of http servers. The tracking runs first, then the server's own ConnState,
+// then hooks in order. Activity is stamped with c.
+func newManaged(addrs []string, srv GracefulServer, c clock, hooks []func(net.Conn, http.ConnState)) *managed {
+	m := &managed{
+		addr:     strings.Join(addrs, ", "),
+		addrs:    addrs,
+		srv:      srv,
+		conns:    connTracker{clock: c},
+		requests: requestTracker{clock: c},
+	}
+
+	if server, ok := srv.(*http.Server); ok {
+		m.http = server
+
+		connState := server.ConnState
+		server.ConnState = func(c net.Conn, state http.ConnState) {
+			m.conns.track(c, state)
+
+			if connState != nil {
+				connState(c, state)
+			}
+
+			for _, hook := range hooks {
+				hook(c, state)
+			}
+		}
+	}
+
+	return m
+}
+
+// name describes the kind of server for log messages
+func (m *managed) name() string {
+	if m.http != nil {
+		return "HTTP Server"
+	}
+
+	return "Server"
+}
+
+// addServer adds an actor serving each of m's listeners to the group
+func (s *Server) addServer(group *runGroup, m *managed) {
+	if m.http != nil {
+		m.http.RegisterOnShutdown(func() {
+			s.logger.Infof("Draining %d connections on [%s]", m.conns.count(), m.addr)
+		})
+	}
+
+	for _, ln := range m.listeners {
+		s.addListener(group, m, ln)
+	}
+}
+
+// addListener adds an actor serving m on ln to the group
+func (s *Server) addListener(group *runGroup, m *managed, ln net.Listener) {
+	// Log the resolved address so port 0 shows the port actually chosen
+	addr := ln.Addr().String()
+
+	group.Add(
+		fmt.Sprintf("%s [%s]", m.name(), addr),
+		func() error {
+			if s.startupLog != nil {
+				s.startupLog(ln.Addr(), os.Getpid())
+			} else {
+				s.logger.Infof("Listening on [%s] with pid [%d]", addr, os.Getpid())
+			}
+			s.emit(Event{Type: EventListening, Addr: addr})
+
+			var err error
+			if m.http != nil && s.certs != nil {
+				err = m.http.ServeTLS(ln, "", "")
+			} else {
+				err = m.srv.Serve(ln)
+			}
+			if err == nil || DefaultClassifier(err) == SeverityIgnore {
+				return nil
+			}
+
+			s.logger.Errorf("%s [%s] failed: %v", m.name(), addr, err)
+			s.emit(Event{Type: EventError, Addr: addr, Err: err})
+			return withKind(ErrServe, err)
+		},
+		s.stop,
+	)
+}
+
+// serverTimeouts are the timeouts applied to http servers that don't set them
+type serverTimeouts struct {
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+	readHeader time.Duration
+}
+
+// apply sets the timeouts server leaves at zero
+func (t serverTimeouts) apply(server *http.Server) {
+	if server.ReadTimeout == 0 {
+		server.ReadTimeout = t.read
+	}
+	if server.WriteTimeout == 0 {
+		server.WriteTimeout = t.write
+	}
+	if server.IdleTimeout == 0 {
+		server.IdleTimeout = t.idle
+	}
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = t.readHeader
+	}
+}
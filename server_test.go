@@ -0,0 +1,60 @@
+package graceful
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegisterDuringOptions(t *testing.T) {
+	ln := listen(t)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// Like admin.WithServer, an option registers a server before the options
+	// after it are applied
+	var hooked int32
+	s := newTestServer(t,
+		func(s *Server) {
+			s.Register(addr, &http.Server{Handler: http.NotFoundHandler()})
+		},
+		WithConnStateHook(func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&hooked, 1)
+			}
+		}),
+	)
+	clock := newFakeClock()
+	s.clock = clock
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve() }()
+	select {
+	case <-s.ready:
+	case err := <-errc:
+		t.Fatalf("Serve returned %v before it was ready", err)
+	}
+
+	resp, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if n := atomic.LoadInt32(&hooked); n != 1 {
+		t.Errorf("the ConnState hook saw %d new connections, want 1", n)
+	}
+	for _, m := range s.snapshot().servers {
+		if m.conns.clock != clock {
+			t.Errorf("%s [%s] doesn't use the server's clock", m.name(), m.addr)
+		}
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	waitErr(t, errc, "Serve")
+}
@@ -0,0 +1,549 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codechimp-io/graceful/internal/drain"
+)
+
+// stop drains the servers once, interrupting the caller's actors with cause
+func (s *Server) stop(cause error) {
+	s.drainOnce.Do(func() {
+		s.drain(cause)
+	})
+}
+
+// ShutdownCause is what started a shutdown: the name of the signal received,
+// such as "SIGTERM", or one of the causes below
+type ShutdownCause string
+
+// Shutdown causes other than signals
+const (
+	CauseShutdown        ShutdownCause = "Shutdown"
+	CauseContext         ShutdownCause = "context cancellation"
+	CauseUpgrade         ShutdownCause = "upgrade"
+	CauseIdleTimeout     ShutdownCause = "idle timeout"
+	CauseStartupTimeout  ShutdownCause = "startup timeout"
+	CauseSelfHealthCheck ShutdownCause = "self health check"
+	CauseMemoryPressure  ShutdownCause = "memory pressure"
+	// CauseError is an actor returning an error, such as a server failing
+	CauseError ShutdownCause = "error"
+	// CauseActorExit is an actor or worker returning without an error
+	CauseActorExit ShutdownCause = "actor exit"
+)
+
+// causeKey is the context key for the ShutdownCause
+type causeKey struct{}
+
+// CauseFromContext returns the cause of the shutdown ctx belongs to, as
+// passed to the WithPreShutdown and WithPostShutdown hooks, or "" for other
+// contexts. Hooks can use it to skip work on an upgrade, for example.
+func CauseFromContext(ctx context.Context) ShutdownCause {
+	cause, _ := ctx.Value(causeKey{}).(ShutdownCause)
+	return cause
+}
+
+// setShutdownCause records what started the shutdown, unless something
+// already did
+func (s *Server) setShutdownCause(cause ShutdownCause) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shutdownCause == "" {
+		s.shutdownCause = cause
+	}
+}
+
+// ShutdownCause returns what started the shutdown, or "" while the servers
+// are running. It is set by the time Draining is closed, so handlers watching
+// it can tell an upgrade from a signal. It is logged as Serve returns.
+func (s *Server) ShutdownCause() ShutdownCause {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.shutdownCause
+}
+
+// drain runs the shutdown hooks around shutting down all servers and actors.
+// A forced shutdown cancels every remaining step, so the servers are closed
+// without waiting for their connections.
+func (s *Server) drain(cause error) {
+	start := s.clock.Now()
+	s.startExitWatchdog()
+
+	// Don't hand the listeners to a new process while we drain them
+	s.stopUpgrades()
+
+	// Every step shares the overall shutdown budget
+	ctx, cancel := s.drainContext()
+	defer cancel()
+
+	atomic.StoreInt64(&s.drainStarted, s.clock.Now().UnixNano())
+	atomic.StoreInt32(&s.draining, 1)
+	s.setState(StateDraining)
+	close(s.drainingC)
+	var conns int
+	for _, m := range s.servers {
+		conns += m.conns.count()
+	}
+
+	s.emit(Event{Type: EventShutdownStarted, Conns: conns})
+
+	if s.childSignaling {
+		s.signalChildren()
+	}
+
+	// Stay healthy while ShuttingDown lets handlers turn new work away
+	if s.lameDuck > 0 {
+		s.logger.Infof("Entering lame duck mode for %s", s.lameDuck)
+		sleepContext(ctx, s.clock, s.lameDuck)
+	}
+
+	s.setHealthy(false)
+
+	// Infrastructure connections such as profiler streams would only hold
+	// the drain up
+	s.closeEager()
+
+	// Close keep-alive connections after their current request so they
+	// don't keep sending work during the drain
+	if s.disableKeepAlives {
+		for _, m := range s.servers {
+			if m.http != nil {
+				m.http.SetKeepAlivesEnabled(false)
+			}
+		}
+	}
+
+	var errs []error
+
+	err := s.sd.stopping()
+	if err != nil {
+		s.logger.Errorf("Notifying systemd failed: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+	}
+
+	for _, hook := range s.preShutdown {
+		err := hook(ctx)
+		if err != nil {
+			s.logger.Errorf("Pre-shutdown hook failed: %v", err)
+			s.emit(Event{Type: EventError, Err: err})
+			errs = append(errs, fmt.Errorf("pre-shutdown hook: %w", err))
+		}
+	}
+
+	// Keep serving until the readiness probes have seen the health check fail
+	if s.probeDelay > 0 {
+		s.logger.Infof("Waiting %s for readiness probes to see the health check fail", s.probeDelay)
+		sleepContext(ctx, s.clock, s.probeDelay)
+	}
+
+	// Keep serving while load balancers stop routing to us
+	if s.drainDelay > 0 {
+		s.logger.Infof("Waiting %s before shutting down", s.drainDelay)
+		sleepContext(ctx, s.clock, s.drainDelay)
+	}
+
+	// Requests accepted during the delays are still wanted, so their contexts
+	// are only cancelled once the servers stop accepting
+	if s.cancelBase != nil {
+		s.cancelBase()
+	}
+
+	var (
+		forceClosed int64
+		serverErrs  = make([]error, len(s.servers))
+	)
+	stopProgress := s.reportProgress()
+	for _, priority := range s.priorities() {
+		var wg sync.WaitGroup
+		for i, m := range s.servers {
+			if m.priority != priority {
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int, m *managed) {
+				defer wg.Done()
+
+				forced, err := s.shutdownServer(ctx, m)
+				atomic.AddInt64(&forceClosed, int64(forced))
+				serverErrs[i] = err
+			}(i, m)
+		}
+		wg.Wait()
+
+		errs = append(errs, s.interruptActors(ctx, priority, cause))
+	}
+	stopProgress(int(forceClosed))
+
+	// Keep server errors in registration order
+	errs = append(errs, serverErrs...)
+
+	if s.childSignaling {
+		s.waitChildren(ctx)
+	}
+
+	for _, hook := range s.postShutdown {
+		err := hook(ctx)
+		if err != nil {
+			s.logger.Errorf("Post-shutdown hook failed: %v", err)
+			s.emit(Event{Type: EventError, Err: err})
+			errs = append(errs, fmt.Errorf("post-shutdown hook: %w", err))
+		}
+	}
+
+	if atomic.LoadInt32(&s.handedOff) == 1 {
+		for _, fn := range s.handoffComplete {
+			fn()
+		}
+	}
+
+	err = joinErrors(errs...)
+
+	elapsed := since(s.clock, start)
+
+	s.mu.Lock()
+	s.drainErr = err
+	s.lastShutdown = elapsed
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Errorf("Shutdown completed with errors in %s: %v", elapsed.Round(time.Millisecond), err)
+	} else {
+		s.logger.Infof("Shutdown completed in %s", elapsed.Round(time.Millisecond))
+	}
+
+	s.emit(Event{
+		Type:        EventShutdownComplete,
+		Duration:    elapsed,
+		Err:         err,
+		ForceClosed: int(forceClosed),
+	})
+}
+
+// drainContext returns the context bounding a drain. It is done when the
+// shutdown is forced, the shutdown parent context is done or the shutdown
+// deadline passes, whichever comes first, and logs which it was.
+func (s *Server) drainContext() (context.Context, context.CancelFunc) {
+	parent := s.shutdownParent
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, cancelParent := context.WithCancel(context.WithValue(parent, causeKey{}, s.ShutdownCause()))
+	cancel := cancelParent
+
+	go func() {
+		select {
+		case <-s.forceC:
+			cancelParent()
+		case <-ctx.Done():
+		}
+	}()
+
+	if s.shutdownDeadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = withTimeout(ctx, s.clock, s.shutdownDeadline)
+		cancel = func() {
+			cancelDeadline()
+			cancelParent()
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+
+		select {
+		case <-finished:
+		case <-s.forceC:
+		default:
+			if err := parent.Err(); err != nil {
+				s.logger.Warnf("Shutdown parent context done, aborting the drain: %v", err)
+			} else {
+				s.logger.Warnf("Shutdown deadline of %s exceeded, aborting the drain", s.shutdownDeadline)
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(finished)
+		cancel()
+	}
+}
+
+// priorities returns the distinct shutdown priorities of the servers and
+// actors in ascending order
+func (s *Server) priorities() []int {
+	seen := make(map[int]bool)
+	for _, m := range s.servers {
+		seen[m.priority] = true
+	}
+	for _, a := range s.actors {
+		seen[a.priority] = true
+	}
+
+	priorities := make([]int, 0, len(seen))
+	for priority := range seen {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	return priorities
+}
+
+// interruptActors interrupts the actors with priority and waits until they
+// have returned or ctx is done. It returns the panics of their interrupts.
+func (s *Server) interruptActors(ctx context.Context, priority int, cause error) error {
+	// A panicking interrupt must not cut the rest of the drain short
+	var errs []error
+	for _, a := range s.actors {
+		if a.priority == priority {
+			a := a
+			errs = append(errs, s.recoverPanic(func() error {
+				a.interrupt(cause)
+				return nil
+			})())
+		}
+	}
+
+	for _, a := range s.actors {
+		if a.priority != priority || a.done == nil {
+			continue
+		}
+
+		select {
+		case <-a.done:
+		case <-ctx.Done():
+			return joinErrors(errs...)
+		}
+	}
+
+	return joinErrors(errs...)
+}
+
+// shutdownServer gracefully shuts the server down within its shutdown timeout
+// and the deadline of ctx.
+// It returns the number of connections that had to be force-closed and the
+// shutdown and close errors.
+func (s *Server) shutdownServer(ctx context.Context, m *managed) (int, error) {
+	s.logger.Infof("Shutting %s [%s] down", m.name(), m.addr)
+
+	timeout := s.shutdownTimeout
+	if m.shutdownTimeout > 0 {
+		timeout = m.shutdownTimeout
+	}
+
+	// After an upgrade the new process serves, so the old one may take longer
+	if s.upgradeDrainTimeout > 0 && atomic.LoadInt32(&s.handedOff) == 1 {
+		timeout = s.upgradeDrainTimeout
+	}
+
+	parent := ctx
+	ctx, cancel := drain.Context(ctx, timeout)
+	defer cancel()
+
+	// End the drain early, leaving the reserve to close the connections that
+	// remain before the hard deadline
+	if deadline, ok := ctx.Deadline(); ok && s.shutdownReserve > 0 {
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-s.shutdownReserve))
+		defer cancel()
+	}
+
+	var errs []error
+
+	stopCountdown := s.logCountdown(ctx, m)
+
+	start := s.clock.Now()
+	err := m.srv.Shutdown(ctx)
+
+	// An error other than the deadline, such as from closing a listener,
+	// ends Shutdown early; retry once within what is left of the timeout
+	// rather than force-close connections that are still draining
+	if err != nil && ctx.Err() == nil && m.conns.count() > 0 {
+		s.logger.Warnf("Shutting %s [%s] down failed, retrying: %s", m.name(), m.addr, err)
+		err = m.srv.Shutdown(ctx)
+	}
+
+	// Shutdown doesn't wait for h2c connections, only sends them GOAWAY
+	var h2cActive int
+	if err == nil && m.h2c != nil {
+		h2cActive = m.h2c.wait(ctx)
+		if h2cActive > 0 {
+			err = fmt.Errorf("%d h2c connections still active: %w", h2cActive, ctx.Err())
+		}
+	}
+	stopCountdown()
+	elapsed := since(s.clock, start).Round(time.Millisecond)
+	if err != nil {
+		s.logger.Errorf("Error shutting down %s: %s", m.name(), err)
+		s.emit(Event{Type: EventError, Addr: m.addr, Err: err})
+		serr := fmt.Errorf("shutting down %s [%s]: %w", m.name(), m.addr, err)
+		if ctx.Err() != nil {
+			serr = withKind(ErrDrainTimeout, serr)
+		}
+		errs = append(errs, serr)
+	} else {
+		s.logger.Infof("%s [%s] drained in %s", m.name(), m.addr, elapsed)
+	}
+
+	// Hijacked connections get a drain of their own once the requests are
+	// done, bounded by the hijack timeout rather than the shutdown timeout.
+	// After an upgrade the old process lingers for them instead.
+	hijackTimeout := s.hijackTimeout
+	if s.upgradeLinger > 0 && atomic.LoadInt32(&s.handedOff) == 1 {
+		hijackTimeout = s.upgradeLinger
+	}
+
+	var hijacked int
+	if err == nil && hijackTimeout > 0 {
+		hijacked = s.drainHijacked(parent, m, hijackTimeout)
+		if hijacked > 0 {
+			err = fmt.Errorf("%d hijacked connections still open", hijacked)
+			s.emit(Event{Type: EventError, Addr: m.addr, Err: err})
+			errs = append(errs, withKind(ErrDrainTimeout, fmt.Errorf("shutting down %s [%s]: %w", m.name(), m.addr, err)))
+		}
+	}
+
+	// A clean drain leaves nothing to close, and unless they were
+	// registered, hijacked connections such as WebSockets are left to
+	// finish on their own
+	if m.http == nil || err == nil || !s.forceClose {
+		return 0, joinErrors(errs...)
+	}
+
+	forced := m.conns.count() + h2cActive + hijacked
+	if m.h2c != nil {
+		m.h2c.cancel()
+	}
+	if hijackTimeout > 0 {
+		m.hijacked.closeAll()
+	}
+	if forced > 0 {
+		s.logger.Errorf("%s [%s] didn't drain within %s, force-closing %d connections", m.name(), m.addr, since(s.clock, start).Round(time.Millisecond), forced)
+	}
+	if s.drainDiagnostics {
+		if n, summary := m.requests.summary(); n > 0 {
+			s.logger.Errorf("%s [%s] force-closed %d requests: %s", m.name(), m.addr, n, summary)
+		}
+	}
+
+	err = m.http.Close()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("closing %s [%s]: %w", m.name(), m.addr, err))
+	}
+
+	return forced, joinErrors(errs...)
+}
+
+// forceShutdown makes a running or future drain close the servers immediately
+func (s *Server) forceShutdown() {
+	s.forceOnce.Do(func() {
+		close(s.forceC)
+	})
+}
+
+// stopUpgrades waits for an upgrade in progress to finish and rejects new ones
+func (s *Server) stopUpgrades() {
+	if atomic.LoadInt32(&s.upgrading) == 1 {
+		s.logger.Infof("Waiting for the upgrade in progress to finish before shutting down")
+	}
+
+	s.upgradeMu.Lock()
+	s.stopping = true
+	s.upgradeMu.Unlock()
+}
+
+// reportProgress calls the WithDrainProgress callback with the open
+// connections every interval until the returned function is called, which
+// makes the final call with the number of connections force-closed
+func (s *Server) reportProgress() func(forced int) {
+	if s.drainProgress == nil {
+		return func(int) {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := s.clock.NewTicker(s.drainProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				s.drainProgress(s.openConns())
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func(forced int) {
+		close(stop)
+		<-done
+		s.drainProgress(forced)
+	}
+}
+
+// logCountdown logs the time left until ctx's deadline and the open
+// connections of m every WithDrainCountdown interval until the returned
+// function is called
+func (s *Server) logCountdown(ctx context.Context, m *managed) func() {
+	if s.countdownInterval <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := s.clock.NewTicker(s.countdownInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				conns, _ := m.conns.idle()
+				if deadline, ok := ctx.Deadline(); ok {
+					remaining := deadline.Sub(s.clock.Now()).Round(time.Second)
+					s.logger.Infof("Draining %s [%s]: %s remaining, %d connections active", m.name(), m.addr, remaining, conns)
+				} else {
+					s.logger.Infof("Draining %s [%s]: %d connections active", m.name(), m.addr, conns)
+				}
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// sleepContext waits for d on c or until ctx is done
+func sleepContext(ctx context.Context, c clock, d time.Duration) {
+	timer := c.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,47 @@
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrainDelayKeepsRequestContextLive(t *testing.T) {
+	ctxErrs := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxErrs <- r.Context().Err()
+	})}
+
+	clock := newFakeClock()
+	s := newTestServer(t,
+		WithDrainDelay(10*time.Second),
+		WithBaseContext(context.Background()),
+	)
+	s.clock = clock
+	ln := listen(t)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, srv) }()
+	<-s.ready
+
+	shutdownc := make(chan error, 1)
+	go func() { shutdownc <- s.Shutdown(context.Background()) }()
+	<-s.Draining()
+	clock.BlockUntil(1)
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("request during the drain delay: %v", err)
+	}
+	resp.Body.Close()
+	if err := <-ctxErrs; err != nil {
+		t.Errorf("request during the drain delay has a done context: %v", err)
+	}
+
+	clock.Advance(10 * time.Second)
+	if err := <-shutdownc; err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	<-errc
+}
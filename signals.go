@@ -0,0 +1,189 @@
+package graceful
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var signalNames = map[os.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// signalName returns the conventional name of sig
+func signalName(sig os.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+
+	return sig.String()
+}
+
+// validateSignals rejects signals registered for both shutdown and upgrade,
+// and handlers for either
+func validateSignals(shutdown, upgrade []os.Signal, handlers []signalHandler) error {
+	for _, a := range shutdown {
+		for _, b := range upgrade {
+			if a == b {
+				return fmt.Errorf("graceful: %s is configured as both a shutdown and an upgrade signal", signalName(a))
+			}
+		}
+	}
+
+	for _, h := range handlers {
+		if containsSignal(shutdown, h.sig) || containsSignal(upgrade, h.sig) {
+			return fmt.Errorf("graceful: %s has a handler but is also a shutdown or upgrade signal", signalName(h.sig))
+		}
+	}
+
+	return nil
+}
+
+// signalHandler is a handler registered with WithSignalHandler
+type signalHandler struct {
+	sig os.Signal
+	fn  func()
+}
+
+// addSignalHandlers adds an actor running the WithSignalHandler handlers for
+// each signal received until the group is interrupted
+func (s *Server) addSignalHandlers(group *runGroup) {
+	var (
+		cancel = make(chan struct{})
+		ch     = s.handlerCh
+		sigs   []os.Signal
+	)
+	for _, h := range s.signalHandlers {
+		sigs = append(sigs, h.sig)
+	}
+
+	group.Add(
+		"signal handlers",
+		func() error {
+			s.notify(ch, sigs...)
+
+			for {
+				select {
+				case sig := <-ch:
+					s.logger.Infof("Received %s, running its handlers", signalName(sig))
+					for _, h := range s.signalHandlers {
+						if h.sig == sig {
+							h.fn()
+						}
+					}
+
+				case <-cancel:
+					return nil
+				}
+			}
+		},
+		func(e error) {
+			s.stopNotify(ch)
+			close(cancel)
+		},
+	)
+}
+
+// Signal delivers sig to the running Server as if it came from the operating
+// system, so the shutdown and upgrade paths can be driven without sending real
+// signals to the process. Signals that aren't configured are ignored, and like
+// os/signal, a signal is dropped if the previous one hasn't been handled yet.
+//
+// For example, a test can serve on an ephemeral port and drain it with:
+//
+//	s := graceful.New(graceful.WithUpgrades(false), graceful.WithReadyCallback(func() { close(ready) }))
+//	go func() { done <- s.ServeListener(ln, srv) }()
+//	<-ready
+//	s.Signal(syscall.SIGTERM)
+//	err := <-done
+func (s *Server) Signal(sig os.Signal) {
+	ch := s.shutdownCh
+	if containsSignal(s.upgradeSignals, sig) {
+		ch = s.upgradeCh
+	} else if s.handlesSignal(sig) {
+		ch = s.handlerCh
+	} else if !containsSignal(s.shutdownSignals, sig) {
+		return
+	}
+
+	select {
+	case ch <- sig:
+	default:
+	}
+}
+
+// forceOnSignal forces the shutdown if first arrives on ch again before stop
+// is closed, or any shutdown signal does if the shutdown didn't start with
+// one. Other signals are ignored.
+func (s *Server) forceOnSignal(ch <-chan os.Signal, first os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case sig := <-ch:
+			if first != nil && sig != first {
+				s.logger.Infof("Ignoring %s received during the drain, send %s again to shut down immediately", signalName(sig), signalName(first))
+				continue
+			}
+
+			s.logger.Warnf("Received %s again, shutting down immediately...", signalName(sig))
+			s.forceShutdown()
+			return
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+// discardSignals drops the signals that arrived on ch while the previous one
+// was being handled
+func (s *Server) discardSignals(ch <-chan os.Signal) {
+	for {
+		select {
+		case sig := <-ch:
+			s.logger.Infof("Ignoring %s received during the upgrade", signalName(sig))
+		default:
+			return
+		}
+	}
+}
+
+// handlesSignal reports whether sig has a WithSignalHandler handler
+func (s *Server) handlesSignal(sig os.Signal) bool {
+	for _, h := range s.signalHandlers {
+		if h.sig == sig {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsSignal(sigs []os.Signal, sig os.Signal) bool {
+	for _, s := range sigs {
+		if s == sig {
+			return true
+		}
+	}
+
+	return false
+}
+
+// warnInit warns when running as PID 1, typically as a container's entrypoint.
+// Shutdown signals still work as they are handled explicitly, but nothing
+// reaps orphaned processes, and the old process exiting after an upgrade ends
+// the container.
+func (s *Server) warnInit() {
+	if os.Getpid() != 1 {
+		return
+	}
+
+	if s.upgrades {
+		s.logger.Warnf("Running as PID 1: an upgrade stops the container when the old process exits, run under an init such as tini or disable upgrades")
+		return
+	}
+
+	s.logger.Warnf("Running as PID 1: orphaned processes won't be reaped, consider running under an init such as tini")
+}
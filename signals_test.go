@@ -0,0 +1,281 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalDrainsInFlightRequest(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})}
+
+	s := newTestServer(t)
+	ln := listen(t)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, srv) }()
+	<-s.ready
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		resc <- result{body: string(b), err: err}
+	}()
+
+	<-started
+	s.Signal(syscall.SIGTERM)
+	<-s.Draining()
+	close(release)
+
+	res := <-resc
+	if res.err != nil || res.body != "done" {
+		t.Errorf("in-flight request got %q, %v, want it to finish", res.body, res.err)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Serve returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after SIGTERM")
+	}
+}
+
+// servePending serves a handler that blocks until release is closed,
+// returning the channel Serve returns on once a request is in flight and the
+// channel its client's error arrives on
+func servePending(t *testing.T, s *Server, release <-chan struct{}) (serve, client <-chan error) {
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ln := listen(t)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, srv) }()
+	<-s.ready
+
+	clientc := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			_, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		clientc <- err
+	}()
+	<-started
+
+	return errc, clientc
+}
+
+// waitErr returns what arrives on c, failing the test after 5s
+func waitErr(t *testing.T, c <-chan error, what string) error {
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s didn't return", what)
+		return nil
+	}
+}
+
+func TestSecondSignalForcesShutdown(t *testing.T) {
+	s := newTestServer(t)
+	release := make(chan struct{})
+	defer close(release)
+	serve, client := servePending(t, s, release)
+
+	s.Signal(syscall.SIGTERM)
+	<-s.Draining()
+	s.Signal(syscall.SIGTERM)
+
+	if err := waitErr(t, client, "the in-flight request"); err == nil {
+		t.Error("in-flight request finished, want it cut off by the forced shutdown")
+	}
+	waitErr(t, serve, "Serve")
+}
+
+func TestDifferentSecondSignalDoesntForceShutdown(t *testing.T) {
+	s := newTestServer(t)
+	release := make(chan struct{})
+	serve, client := servePending(t, s, release)
+
+	s.Signal(syscall.SIGTERM)
+	<-s.Draining()
+	s.Signal(os.Interrupt)
+
+	select {
+	case err := <-client:
+		t.Fatalf("in-flight request ended with %v after a different signal", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	if err := waitErr(t, client, "the in-flight request"); err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+	if err := waitErr(t, serve, "Serve"); err != nil {
+		t.Errorf("Serve returned %v, want nil", err)
+	}
+}
+
+func TestShutdownSignalDuringUpgrade(t *testing.T) {
+	u := newFakeUpgrader()
+	u.err = errors.New("child failed to start")
+	u.block = make(chan struct{})
+	s, serve := serveFake(t, u)
+
+	s.Signal(syscall.SIGHUP)
+	<-u.upgrades
+	s.Signal(syscall.SIGTERM)
+
+	// The drain waits for the upgrade rather than closing the listeners the
+	// new process is taking over
+	time.Sleep(100 * time.Millisecond)
+	if st := s.State(); st != StateServing {
+		t.Errorf("State() = %s during the upgrade, want %s", st, StateServing)
+	}
+	resp, err := http.Get("http://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("request during the upgrade: %v", err)
+	}
+	resp.Body.Close()
+
+	close(u.block)
+	if err := waitErr(t, serve, "Serve"); err != nil {
+		t.Errorf("Serve returned %v, want nil", err)
+	}
+	if cause, want := s.ShutdownCause(), ShutdownCause(signalName(syscall.SIGTERM)); cause != want {
+		t.Errorf("ShutdownCause() = %q, want %q", cause, want)
+	}
+}
+
+func TestQuickSignalsAreBothHandled(t *testing.T) {
+	s := newTestServer(t)
+	release := make(chan struct{})
+	defer close(release)
+	serve, client := servePending(t, s, release)
+
+	// Both arrive before the first is handled
+	s.Signal(syscall.SIGTERM)
+	s.Signal(syscall.SIGTERM)
+
+	if err := waitErr(t, client, "the in-flight request"); err == nil {
+		t.Error("in-flight request finished, want the second signal to force the shutdown")
+	}
+	waitErr(t, serve, "Serve")
+}
+
+func TestUpgradeSignalsDuringUpgradeAreCoalesced(t *testing.T) {
+	u := newFakeUpgrader()
+	u.err = errors.New("child failed to start")
+	u.block = make(chan struct{})
+	s, serve := serveFake(t, u)
+
+	s.Signal(syscall.SIGHUP)
+	<-u.upgrades
+	s.Signal(syscall.SIGHUP)
+	s.Signal(syscall.SIGHUP)
+	close(u.block)
+
+	select {
+	case <-u.upgrades:
+		t.Error("SIGHUP received during an upgrade started another one")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	err := s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	waitErr(t, serve, "Serve")
+}
+
+func TestServeDoesntLeakSignalHandlers(t *testing.T) {
+	var mu sync.Mutex
+	installed := make(map[chan<- os.Signal]bool)
+
+	// os/signal starts a goroutine of its own on the first Notify, for good
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	signal.Stop(c)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		u := newFakeUpgrader()
+		s := newTestServer(t,
+			WithUpgrades(true),
+			WithUpgrader(func(UpgraderOptions) (Upgrader, error) {
+				return u, nil
+			}),
+		)
+		s.notify = func(c chan<- os.Signal, sigs ...os.Signal) {
+			mu.Lock()
+			installed[c] = true
+			mu.Unlock()
+			signal.Notify(c, sigs...)
+		}
+		s.stopNotify = func(c chan<- os.Signal) {
+			signal.Stop(c)
+			mu.Lock()
+			delete(installed, c)
+			mu.Unlock()
+		}
+
+		ln := listen(t)
+		srv := &http.Server{Handler: http.NotFoundHandler()}
+		errc := make(chan error, 1)
+		go func() { errc <- s.ServeListener(ln, srv) }()
+		<-s.ready
+
+		err := s.Shutdown(context.Background())
+		if err != nil {
+			t.Fatalf("Shutdown returned %v, want nil", err)
+		}
+		waitErr(t, errc, "Serve")
+	}
+
+	mu.Lock()
+	if n := len(installed); n > 0 {
+		t.Errorf("%d signal handlers left installed after Serve returned", n)
+	}
+	mu.Unlock()
+
+	// The goroutines of the runs exit shortly after Serve returns
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines before serving, %d after:\n%s", before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,78 @@
+// Package simple gracefully shuts an http server down on SIGINT or SIGTERM,
+// without the upgrade support of package graceful and its dependencies.
+//
+// Run is the drain-only counterpart of graceful.Run. It lives in its own
+// package rather than as a SimpleRun function of package graceful because
+// importing that package links tableflip and oklog/run whichever functions
+// are used; simple.Run reads the same at the call site.
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codechimp-io/graceful/internal/drain"
+)
+
+// DefaultShutdownTimeout is how long Run waits for connections to drain
+// unless WithShutdownTimeout is given
+const DefaultShutdownTimeout = 3 * time.Second
+
+// config is the configuration of a Run
+type config struct {
+	shutdownTimeout time.Duration
+}
+
+// Option configures Run
+type Option func(*config)
+
+// WithShutdownTimeout sets how long Run waits for connections to drain. Zero
+// waits until they have.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.shutdownTimeout = d
+	}
+}
+
+// Run serves server until SIGINT or SIGTERM is received or ctx is done, then
+// shuts it down gracefully within the shutdown timeout. It returns nil after
+// a clean shutdown.
+func Run(ctx context.Context, server *http.Server, opts ...Option) error {
+	c := config{shutdownTimeout: DefaultShutdownTimeout}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("serving: %w", err)
+
+	case <-sig:
+	case <-ctx.Done():
+	}
+
+	err := drain.Shutdown(context.Background(), server, c.shutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package graceful
+
+import "fmt"
+
+// addStartupTimeout adds an actor that fails the group if it doesn't become
+// ready before the startup timeout
+func (s *Server) addStartupTimeout(group *runGroup) {
+	cancel := make(chan struct{})
+
+	group.Add(
+		"startup timeout",
+		func() error {
+			select {
+			case <-s.ready:
+			case <-s.startCtx.Done():
+				s.setShutdownCause(CauseStartupTimeout)
+				s.logger.Errorf("Not ready within the startup timeout of %s, exiting...", s.startupTimeout)
+				return fmt.Errorf("graceful: not ready within the startup timeout of %s", s.startupTimeout)
+			case <-cancel:
+				return nil
+			}
+
+			<-cancel
+			return nil
+		},
+		func(e error) {
+			close(cancel)
+		},
+	)
+}
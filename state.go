@@ -0,0 +1,51 @@
+package graceful
+
+import "sync/atomic"
+
+// State is a phase of the Server lifecycle. It only moves forward, in the
+// order the states are declared.
+type State int32
+
+// Lifecycle states
+const (
+	// StateStarting is the state until the listeners are bound and ready
+	StateStarting State = iota
+	// StateServing is the state once the servers are serving
+	StateServing
+	// StateDraining is the state once shutdown has begun
+	StateDraining
+	// StateStopped is the state once Serve has returned or is about to
+	StateStopped
+)
+
+var stateNames = map[State]string{
+	StateStarting: "starting",
+	StateServing:  "serving",
+	StateDraining: "draining",
+	StateStopped:  "stopped",
+}
+
+func (st State) String() string {
+	if name, ok := stateNames[st]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// State returns the current lifecycle state. It is safe to call from any
+// goroutine.
+func (s *Server) State() State {
+	return State(atomic.LoadInt32(&s.state))
+}
+
+// setState moves the state forward to st, leaving it unchanged if it is
+// already at or past st
+func (s *Server) setState(st State) {
+	for {
+		cur := atomic.LoadInt32(&s.state)
+		if State(cur) >= st || atomic.CompareAndSwapInt32(&s.state, cur, int32(st)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package graceful
+
+import "time"
+
+// Stats is a snapshot of a Server's lifecycle counters
+type Stats struct {
+	// Ready is true while the servers are listening and not shutting down
+	Ready bool
+	// ShuttingDown is true once shutdown has begun
+	ShuttingDown bool
+	// Connections is the number of open connections
+	Connections int
+	// Requests is the number of requests started on HTTP/1 connections; an
+	// HTTP/2 connection counts as one request
+	Requests uint64
+	// Upgrades is the number of upgrades this process has completed
+	Upgrades int
+	// LastShutdown is how long the last drain took, or zero
+	LastShutdown time.Duration
+}
+
+// Stats returns the current lifecycle counters, for exporting as metrics
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Ready:        s.isHealthy(),
+		ShuttingDown: s.ShuttingDown(),
+		Upgrades:     s.UpgradeCount(),
+	}
+
+	for _, m := range s.snapshot().servers {
+		stats.Connections += m.conns.count()
+		stats.Requests += m.conns.served()
+	}
+
+	s.mu.Lock()
+	stats.LastShutdown = s.lastShutdown
+	s.mu.Unlock()
+
+	return stats
+}
+
+// snapshot is what serve has set up, published once before the servers start
+// so that Stats and the handlers, which run alongside serve, read it safely
+type snapshot struct {
+	servers  []*managed
+	tls      bool
+	upgrades bool
+}
+
+// publish publishes the snapshot of what serve has set up
+func (s *Server) publish() {
+	s.published.Store(snapshot{servers: s.servers, tls: s.certs != nil, upgrades: s.upgrades})
+}
+
+// snapshot returns the published snapshot, which is empty until serve has
+// published it
+func (s *Server) snapshot() snapshot {
+	snap, _ := s.published.Load().(snapshot)
+
+	return snap
+}
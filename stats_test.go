@@ -0,0 +1,47 @@
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestStatsDuringServe(t *testing.T) {
+	s := newTestServer(t)
+	ln := listen(t)
+
+	stop := make(chan struct{})
+	scraped := make(chan struct{})
+	go func() {
+		defer close(scraped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = s.Stats()
+			}
+		}
+	}()
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, &http.Server{Handler: http.NotFoundHandler()}) }()
+	<-s.ready
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := s.Stats().Requests; n != 1 {
+		t.Errorf("Stats().Requests = %d, want 1", n)
+	}
+
+	err = s.Shutdown(context.Background())
+	close(stop)
+	<-scraped
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	<-errc
+}
@@ -0,0 +1,75 @@
+package graceful
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotifier sends service state notifications to systemd
+type sdNotifier struct {
+	addr *net.UnixAddr
+}
+
+// newSDNotifier returns a notifier for NOTIFY_SOCKET, or nil when not running under systemd
+func newSDNotifier() *sdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Abstract sockets are announced with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	return &sdNotifier{
+		addr: &net.UnixAddr{Name: socket, Net: "unixgram"},
+	}
+}
+
+// notify sends state to systemd, doing nothing when n is nil
+func (n *sdNotifier) notify(state string) error {
+	if n == nil {
+		return nil
+	}
+
+	conn, err := net.DialUnix(n.addr.Net, nil, n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// ready tells systemd this process is ready and is now the main process
+func (n *sdNotifier) ready() error {
+	return n.notify("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid()))
+}
+
+// stopping tells systemd this process is shutting down
+func (n *sdNotifier) stopping() error {
+	return n.notify("STOPPING=1")
+}
+
+// watchdogInterval returns how often to ping the systemd watchdog, or 0 if it is disabled
+func (n *sdNotifier) watchdogInterval() time.Duration {
+	if n == nil {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// Ping at half the deadline as recommended by sd_watchdog_enabled(3)
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,108 @@
+package graceful
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// certReloader serves the most recently loaded certificate
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reload loads the certificate from disk, keeping the current one on failure
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// tlsConfig returns a copy of base that serves certificates from r
+func (r *certReloader) tlsConfig(base *tls.Config) *tls.Config {
+	var cfg *tls.Config
+	if base != nil {
+		cfg = base.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+
+	cfg.GetCertificate = r.GetCertificate
+
+	return cfg
+}
+
+// reloadCert loads the TLS certificate again, keeping the current one when
+// that fails
+func (s *Server) reloadCert() {
+	err := s.certs.reload()
+	if err != nil {
+		s.logger.Errorf("Reloading TLS certificate failed, keeping current one: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+		return
+	}
+
+	s.logger.Infof("Reloaded the TLS certificate from %s", s.certs.certFile)
+}
+
+// addCertReload adds an actor reloading the TLS certificate on the upgrade
+// signals, for when upgrades are disabled and they'd otherwise be ignored
+func (s *Server) addCertReload(group *runGroup) {
+	var (
+		cancel = make(chan struct{})
+		sig    = s.upgradeCh
+	)
+
+	group.Add(
+		"certificate reload signals",
+		func() error {
+			s.notify(sig, s.upgradeSignals...)
+
+			for {
+				select {
+				case received := <-sig:
+					s.logger.Infof("Received %s, reloading the TLS certificate...", signalName(received))
+					s.reloadCert()
+				case <-cancel:
+					return nil
+				}
+			}
+		},
+		func(e error) {
+			s.stopNotify(sig)
+			close(cancel)
+		},
+	)
+}
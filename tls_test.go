@@ -0,0 +1,194 @@
+package graceful
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 to dir,
+// returning its files and a pool trusting it
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func TestProxyProtocolBeforeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, pool := writeTestCert(t, dir)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			fmt.Fprint(w, r.RemoteAddr)
+		}),
+	}
+
+	s := newTestServer(t, WithTLS(certFile, keyFile), WithProxyProtocol(true))
+	ln := listen(t)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeListener(ln, srv) }()
+	<-s.ready
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	v4 := proxyV2Addrs(net.IPv4(1, 2, 3, 4).To4(), net.IPv4(5, 6, 7, 8).To4(), 1234, 443)
+	_, err = raw.Write(proxyV2(1, 0x11, v4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := tls.Client(raw, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+	err = c.Handshake()
+	if err != nil {
+		t.Fatalf("TLS handshake after the PROXY header: %v", err)
+	}
+
+	resc := make(chan string, 1)
+	go func() {
+		fmt.Fprint(c, "GET / HTTP/1.0\r\n\r\n")
+		b, _ := ioutil.ReadAll(c)
+		resc <- string(b)
+	}()
+	<-started
+
+	if n := s.Stats().Connections; n != 1 {
+		t.Errorf("Stats().Connections = %d while serving, want 1", n)
+	}
+
+	shutdownc := make(chan error, 1)
+	go func() { shutdownc <- s.Shutdown(context.Background()) }()
+	<-s.Draining()
+
+	if n := s.Stats().Connections; n != 1 {
+		t.Errorf("Stats().Connections = %d while draining, want 1", n)
+	}
+	close(release)
+
+	if res := <-resc; !strings.HasSuffix(res, "\r\n\r\n1.2.3.4:1234") {
+		t.Errorf("response %q, want the PROXY header's address", res)
+	}
+	if err := <-shutdownc; err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	<-errc
+}
+
+// servedCert returns the certificate served on addr
+func servedCert(t *testing.T, addr string) []byte {
+	c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	return c.ConnectionState().PeerCertificates[0].Raw
+}
+
+func TestSignalReloadsCertWithoutUpgrades(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, _ := writeTestCert(t, dir)
+	s := newTestServer(t, WithTLS(certFile, keyFile))
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(srv) }()
+	select {
+	case <-s.ready:
+	case err := <-errc:
+		t.Fatalf("Serve returned %v before it was ready", err)
+	}
+	addr := s.Addr().String()
+	old := servedCert(t, addr)
+
+	// The renewed certificate replaces the files in place
+	writeTestCert(t, dir)
+	s.Signal(syscall.SIGHUP)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bytes.Equal(servedCert(t, addr), old) {
+		if time.Now().After(deadline) {
+			t.Fatal("SIGHUP didn't reload the certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	waitErr(t, errc, "Serve")
+}
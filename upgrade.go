@@ -0,0 +1,185 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/tableflip"
+)
+
+var (
+	errNotServing        = errors.New("graceful: server is not serving")
+	errUpgradeInProgress = errors.New("graceful: upgrade already in progress")
+	errShuttingDown      = errors.New("graceful: server is shutting down")
+	errPrecondition      = errors.New("graceful: upgrade precondition failed")
+)
+
+// upgraded is set once an upgrader finds this process was started by an upgrade
+var upgraded int32
+
+// IsUpgrade reports whether this process was started by an upgrade of a
+// previous one rather than launched fresh. It is only accurate once Serve has
+// created the upgrader, such as in a WithReadyCallback callback, and keeps
+// its value in the old process after it hands over.
+func IsUpgrade() bool {
+	return atomic.LoadInt32(&upgraded) == 1
+}
+
+// upgrade reloads certificates and upgrades to a new process. Only one
+// upgrade runs at a time; concurrent attempts get errUpgradeInProgress.
+// A shutdown waits for the upgrade in progress, and later attempts get
+// errShuttingDown.
+func (s *Server) upgrade() error {
+	if s.upg == nil {
+		return errNotServing
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.upgrading, 0, 1) {
+		return errUpgradeInProgress
+	}
+	defer atomic.StoreInt32(&s.upgrading, 0)
+
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+
+	if s.stopping {
+		return errShuttingDown
+	}
+
+	for _, check := range s.upgradePreconditions {
+		err := check()
+		if err != nil {
+			err = withKind(errPrecondition, fmt.Errorf("graceful: upgrade precondition failed: %w", err))
+			s.logger.Warnf("Skipping upgrade, continuing to serve: %v", err)
+			s.emit(Event{Type: EventError, Err: err})
+			return err
+		}
+	}
+
+	if s.certs != nil {
+		s.reloadCert()
+	}
+
+	restoreEnv, err := s.setUpgradeEnv()
+	if err != nil {
+		err = withKind(ErrUpgrade, err)
+		s.logger.Errorf("Upgrade failed: %v", err)
+		s.emit(Event{Type: EventUpgradeFailed, Err: err})
+		return err
+	}
+
+	start := s.clock.Now()
+	s.emit(Event{Type: EventUpgradeStarted})
+
+	err = s.upg.Upgrade()
+	restoreEnv()
+	elapsed := since(s.clock, start)
+	if err != nil {
+		err = withKind(ErrUpgrade, err)
+		s.emit(Event{Type: EventUpgradeFailed, Duration: elapsed, Err: err})
+
+		if elapsed >= s.effectiveUpgradeTimeout() {
+			s.logger.Errorf("Upgrade timed out after %s, continuing to serve: %v", elapsed.Round(time.Millisecond), err)
+		} else {
+			s.logger.Errorf("Upgrade failed: %v", err)
+		}
+
+		// A failed upgrade never stops this process, it keeps serving
+		for _, handler := range s.upgradeErrorHandlers {
+			handler(err)
+		}
+
+		return err
+	}
+
+	s.mu.Lock()
+	s.upgradeCount++
+	s.lastUpgrade = s.clock.Now()
+	count := s.upgradeCount
+	s.mu.Unlock()
+
+	s.emit(Event{Type: EventUpgradeComplete, Duration: elapsed, Upgrades: count})
+
+	return nil
+}
+
+// UpgradeCount returns the number of successful upgrades this process has
+// coordinated. The count is per process: the new process starts at zero.
+func (s *Server) UpgradeCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.upgradeCount
+}
+
+// LastUpgrade returns when this process last completed an upgrade, or the
+// zero time if it hasn't
+func (s *Server) LastUpgrade() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastUpgrade
+}
+
+// runPostUpgrade runs the post-upgrade hooks once the parent process has
+// exited, unless ctx is done first
+func (s *Server) runPostUpgrade(ctx context.Context, upg Upgrader) {
+	err := upg.WaitForParent(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Errorf("Waiting for the parent process failed: %v", err)
+			s.emit(Event{Type: EventError, Err: err})
+		}
+		return
+	}
+
+	for _, fn := range s.postUpgrade {
+		fn()
+	}
+}
+
+// effectiveUpgradeTimeout returns the upgrade timeout applied by tableflip
+func (s *Server) effectiveUpgradeTimeout() time.Duration {
+	if s.upgradeTimeout > 0 {
+		return s.upgradeTimeout
+	}
+
+	return tableflip.DefaultUpgradeTimeout
+}
+
+// UpgradeHandler returns a handler that triggers a graceful upgrade on POST,
+// equivalent to sending SIGHUP. It responds 202 once the new process has taken
+// over, 409 while another upgrade is in progress, 412 if a precondition set by
+// WithUpgradePrecondition fails and 500 if the upgrade fails.
+// It performs no authentication, so mount it on a protected admin endpoint.
+func (s *Server) UpgradeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.logger.Infof("Upgrade requested over HTTP, restarting gracefully...")
+
+		err := s.upgrade()
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, errUpgradeInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errPrecondition):
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		case errors.Is(err, errUpgradesDisabled):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case errors.Is(err, errNotServing), errors.Is(err, errShuttingDown):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package graceful
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// upgradeEnvKeys lists the names of the variables set by the WithUpgradeEnv
+// hook of the parent, separated by commas
+const upgradeEnvKeys = "GRACEFUL_UPGRADE_ENV"
+
+var (
+	upgradeEnvOnce sync.Once
+	upgradeEnv     []string
+)
+
+// UpgradeEnv returns the "KEY=value" variables the parent process passed
+// with WithUpgradeEnv, or nil if this process wasn't started by such an
+// upgrade. The variables are removed from the environment, so they don't
+// reach later children unless passed again.
+func UpgradeEnv() []string {
+	loadUpgradeEnv()
+
+	return append([]string(nil), upgradeEnv...)
+}
+
+// loadUpgradeEnv takes the variables passed by the parent out of the
+// environment, once
+func loadUpgradeEnv() {
+	upgradeEnvOnce.Do(func() {
+		keys, ok := os.LookupEnv(upgradeEnvKeys)
+		if !ok {
+			return
+		}
+		_ = os.Unsetenv(upgradeEnvKeys)
+
+		for _, key := range strings.Split(keys, ",") {
+			if key == "" {
+				continue
+			}
+
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			upgradeEnv = append(upgradeEnv, key+"="+value)
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+// setUpgradeEnv sets the variables of the WithUpgradeEnv hook in the
+// environment the child inherits, and returns a func that restores the
+// previous values once the child is started
+func (s *Server) setUpgradeEnv() (func(), error) {
+	if s.upgradeEnv == nil {
+		return func() {}, nil
+	}
+
+	env := s.upgradeEnv()
+
+	var keys []string
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 || strings.ContainsRune(kv[:i], ',') {
+			return nil, fmt.Errorf("graceful: invalid upgrade environment variable %q", kv)
+		}
+		keys = append(keys, kv[:i])
+	}
+	env = append(env, upgradeEnvKeys+"="+strings.Join(keys, ","))
+	keys = append(keys, upgradeEnvKeys)
+
+	prev := make(map[string]*string, len(keys))
+	for _, key := range keys {
+		if value, ok := os.LookupEnv(key); ok {
+			prev[key] = &value
+		} else {
+			prev[key] = nil
+		}
+	}
+
+	restore := func() {
+		for key, value := range prev {
+			if value != nil {
+				_ = os.Setenv(key, *value)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}
+	}
+
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		err := os.Setenv(kv[:i], kv[i+1:])
+		if err != nil {
+			restore()
+			return nil, fmt.Errorf("graceful: setting upgrade environment: %w", err)
+		}
+	}
+
+	return restore, nil
+}
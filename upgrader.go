@@ -0,0 +1,131 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudflare/tableflip"
+)
+
+// errUpgradesDisabled is returned when upgrading a Server without upgrades
+var errUpgradesDisabled = errors.New("graceful: upgrades are disabled")
+
+// Upgrader hands listeners over to a new process of the same program. The
+// default is built on tableflip; WithUpgrader selects another.
+type Upgrader interface {
+	// ListenWithCallback returns the listener for addr inherited from the
+	// parent, or the one callback creates, and passes it on to children
+	ListenWithCallback(network, addr string, callback func(network, addr string) (net.Listener, error)) (net.Listener, error)
+	// AddListener passes ln on to children under addr
+	AddListener(network, addr string, ln InheritableListener) error
+	// Ready tells the parent this process has taken over
+	Ready() error
+	// Exit is closed once a child has taken over or Stop is called
+	Exit() <-chan struct{}
+	// Upgrade starts a child and waits until it is ready
+	Upgrade() error
+	// Stop prevents further upgrades and closes Exit
+	Stop()
+	// HasParent reports whether this process was started by an upgrade
+	HasParent() bool
+	// WaitForParent blocks until the parent has exited
+	WaitForParent(ctx context.Context) error
+}
+
+// InheritableListener is a listener whose file can be passed to a child
+type InheritableListener interface {
+	net.Listener
+	syscall.Conn
+}
+
+// UpgraderOptions are the settings a Server passes to its upgrader
+type UpgraderOptions struct {
+	// PIDFile is written with the process ID once ready, if set
+	PIDFile string
+	// UpgradeTimeout bounds how long a child may take to become ready
+	UpgradeTimeout time.Duration
+}
+
+// TableflipUpgrader creates the default upgrader using tableflip
+func TableflipUpgrader(opts UpgraderOptions) (Upgrader, error) {
+	tf, err := tableflip.New(tableflip.Options{
+		PIDFile:        opts.PIDFile,
+		UpgradeTimeout: opts.UpgradeTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tableflipUpgrader{tf}, nil
+}
+
+// tableflipUpgrader adapts *tableflip.Upgrader to Upgrader
+type tableflipUpgrader struct {
+	*tableflip.Upgrader
+}
+
+func (u tableflipUpgrader) AddListener(network, addr string, ln InheritableListener) error {
+	return u.Upgrader.AddListener(network, addr, ln)
+}
+
+// stopUpgrader stops the upgrader exactly once, whichever shutdown path gets
+// there first
+func (s *Server) stopUpgrader() {
+	s.upgStopOnce.Do(func() {
+		atomic.StoreInt32(&s.upgStopped, 1)
+		if s.upg != nil {
+			s.upg.Stop()
+		}
+	})
+}
+
+// noUpgrader binds fresh listeners and never upgrades
+type noUpgrader struct {
+	stopOnce sync.Once
+	exitC    chan struct{}
+}
+
+func newNoUpgrader() *noUpgrader {
+	return &noUpgrader{
+		exitC: make(chan struct{}),
+	}
+}
+
+func (u *noUpgrader) ListenWithCallback(network, addr string, callback func(network, addr string) (net.Listener, error)) (net.Listener, error) {
+	return callback(network, addr)
+}
+
+func (u *noUpgrader) AddListener(network, addr string, ln InheritableListener) error {
+	return nil
+}
+
+func (u *noUpgrader) Ready() error {
+	return nil
+}
+
+func (u *noUpgrader) Exit() <-chan struct{} {
+	return u.exitC
+}
+
+func (u *noUpgrader) Upgrade() error {
+	return errUpgradesDisabled
+}
+
+func (u *noUpgrader) Stop() {
+	u.stopOnce.Do(func() {
+		close(u.exitC)
+	})
+}
+
+func (u *noUpgrader) HasParent() bool {
+	return false
+}
+
+func (u *noUpgrader) WaitForParent(ctx context.Context) error {
+	return nil
+}
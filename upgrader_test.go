@@ -0,0 +1,198 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeUpgrader is an Upgrader that binds fresh listeners and upgrades without
+// starting a process. Upgrade waits for block to be closed if set, then fails
+// with err if set, and otherwise closes exit as if a new process had taken
+// over.
+type fakeUpgrader struct {
+	exit     chan struct{}
+	err      error
+	block    chan struct{}
+	upgrades chan struct{}
+	stopOnce sync.Once
+}
+
+func newFakeUpgrader() *fakeUpgrader {
+	return &fakeUpgrader{
+		exit:     make(chan struct{}),
+		upgrades: make(chan struct{}, 1),
+	}
+}
+
+func (u *fakeUpgrader) ListenWithCallback(network, addr string, callback func(network, addr string) (net.Listener, error)) (net.Listener, error) {
+	return callback(network, addr)
+}
+
+func (u *fakeUpgrader) AddListener(network, addr string, ln InheritableListener) error {
+	return nil
+}
+
+func (u *fakeUpgrader) Ready() error {
+	return nil
+}
+
+func (u *fakeUpgrader) Exit() <-chan struct{} {
+	return u.exit
+}
+
+func (u *fakeUpgrader) Upgrade() error {
+	u.upgrades <- struct{}{}
+	if u.block != nil {
+		<-u.block
+	}
+	if u.err != nil {
+		return u.err
+	}
+	u.Stop()
+
+	return nil
+}
+
+func (u *fakeUpgrader) Stop() {
+	u.stopOnce.Do(func() {
+		close(u.exit)
+	})
+}
+
+func (u *fakeUpgrader) HasParent() bool {
+	return false
+}
+
+func (u *fakeUpgrader) WaitForParent(ctx context.Context) error {
+	return nil
+}
+
+// serveFake serves a server on an ephemeral port with upgrades done by u and
+// opts, returning the Server once it is ready and a channel Serve returns on
+func serveFake(t *testing.T, u *fakeUpgrader, opts ...Option) (*Server, <-chan error) {
+	s := newTestServer(t, append([]Option{
+		WithUpgrades(true),
+		WithUpgrader(func(UpgraderOptions) (Upgrader, error) {
+			return u, nil
+		}),
+	}, opts...)...)
+	srv := &http.Server{
+		Addr:    "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(srv) }()
+
+	select {
+	case <-s.ready:
+	case err := <-errc:
+		t.Fatalf("Serve returned %v before it was ready", err)
+	}
+
+	return s, errc
+}
+
+// waitServe waits for Serve to return nil
+func waitServe(t *testing.T, errc <-chan error) {
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Serve returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+	}
+}
+
+func TestUpgradeSignalCallsUpgrade(t *testing.T) {
+	u := newFakeUpgrader()
+	s, errc := serveFake(t, u)
+
+	s.Signal(syscall.SIGHUP)
+
+	select {
+	case <-u.upgrades:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP didn't call Upgrade")
+	}
+	waitServe(t, errc)
+
+	if n := s.UpgradeCount(); n != 1 {
+		t.Errorf("UpgradeCount() = %d, want 1", n)
+	}
+}
+
+func TestFailedUpgradeKeepsServing(t *testing.T) {
+	u := newFakeUpgrader()
+	u.err = errors.New("child failed to start")
+	s, errc := serveFake(t, u)
+
+	s.Signal(syscall.SIGHUP)
+	<-u.upgrades
+
+	resp, err := http.Get("http://" + s.Addr().String())
+	if err != nil {
+		t.Fatalf("request after a failed upgrade: %v", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("Serve returned %v after a failed upgrade", err)
+	default:
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	waitServe(t, errc)
+}
+
+func TestUpgraderExitStopsServer(t *testing.T) {
+	u := newFakeUpgrader()
+	s, errc := serveFake(t, u)
+	addr := s.Addr().String()
+
+	u.Stop()
+	waitServe(t, errc)
+
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("%s still accepts connections after Exit closed", addr)
+	}
+}
+
+func TestShutdownSignalStopsUpgrader(t *testing.T) {
+	const timeout = time.Second
+	u := newFakeUpgrader()
+	s, errc := serveFake(t, u, WithShutdownTimeout(timeout))
+
+	// Exit only closes once the drain stops the upgrader
+	s.Signal(syscall.SIGTERM)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Serve returned %v, want nil", err)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("Serve didn't return within the shutdown timeout of %s", timeout)
+	}
+
+	select {
+	case <-u.Exit():
+	default:
+		t.Error("the upgrader wasn't stopped")
+	}
+	if cause, want := s.ShutdownCause(), ShutdownCause(signalName(syscall.SIGTERM)); cause != want {
+		t.Errorf("ShutdownCause() = %q, want %q", cause, want)
+	}
+}
@@ -0,0 +1,127 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Validate checks the configuration for server without serving it: the
+// options and addresses are valid, each address can be bound, the PID file
+// directory is writable and the TLS certificate loads. It returns every
+// problem found, and leaves no listener, PID file or directory behind.
+func Validate(server *http.Server, pidfile string, opts ...Option) error {
+	s := New(append([]Option{WithPIDFile(pidfile)}, opts...)...)
+
+	return s.validate(server)
+}
+
+func (s *Server) validate(server *http.Server) error {
+	errs := append([]error(nil), s.optionErrs...)
+
+	err := s.resolveSignalActions()
+	if err == nil {
+		err = validateSignals(s.shutdownSignals, s.upgradeSignals, s.signalHandlers)
+	}
+	errs = append(errs, err)
+
+	addrs := []string{server.Addr}
+	if len(s.addrs) > 0 {
+		addrs = s.addrs
+	}
+
+	err = validateNetwork(s.network)
+	if err != nil {
+		// The addresses can't be checked without a valid network
+		addrs = nil
+		errs = append(errs, err)
+	}
+
+	for _, addr := range addrs {
+		err := validateAddr(addr, s.network)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		errs = append(errs, s.probeBind(addr))
+	}
+
+	errs = append(errs, s.probePIDFile())
+	errs = append(errs, s.checkHandler(server))
+
+	if s.certFile != "" || s.keyFile != "" {
+		_, err := newCertReloader(s.certFile, s.keyFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("loading TLS certificate: %w", err))
+		}
+	}
+
+	return joinErrors(errs...)
+}
+
+// checkHandler warns about a server without a Handler, which serves
+// http.DefaultServeMux and whatever other packages registered on it, or
+// rejects it with WithRequireHandler
+func (s *Server) checkHandler(server *http.Server) error {
+	if server == nil || server.Handler != nil || s.swappedHandler() != nil {
+		return nil
+	}
+
+	if s.requireHandler {
+		return fmt.Errorf("graceful: server [%s] has no Handler and would serve http.DefaultServeMux", server.Addr)
+	}
+
+	s.logger.Warnf("Server [%s] has no Handler, serving http.DefaultServeMux", server.Addr)
+	return nil
+}
+
+// probeBind binds addr and closes the listener straight away
+func (s *Server) probeBind(addr string) error {
+	network, address := splitAddr(addr, s.network)
+
+	listen := func(network, address string) (net.Listener, error) {
+		return s.listenConfig.Listen(context.Background(), network, address)
+	}
+	if s.listenFunc != nil {
+		listen = s.listenFunc
+	}
+
+	ln, err := listen(network, address)
+	err = explainBindErr(network, address, err)
+	if err != nil {
+		return withKind(ErrBind, fmt.Errorf("binding [%s]: %w", addr, err))
+	}
+
+	return ln.Close()
+}
+
+// probePIDFile runs preparePIDFile, removing any directories it created
+func (s *Server) probePIDFile() error {
+	if s.pidfile == "" {
+		return nil
+	}
+
+	// Find the directories that don't exist yet, innermost first
+	var created []string
+	for dir := filepath.Dir(s.pidfile); ; dir = filepath.Dir(dir) {
+		_, err := os.Stat(dir)
+		if err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		created = append(created, dir)
+	}
+
+	err := s.preparePIDFile()
+
+	if s.pidDirMode != 0 {
+		for _, dir := range created {
+			_ = os.Remove(dir)
+		}
+	}
+
+	return err
+}
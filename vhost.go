@@ -0,0 +1,63 @@
+package graceful
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// virtualHost is a handler registered with WithVirtualHost
+type virtualHost struct {
+	host    string
+	handler http.Handler
+}
+
+// serveVirtualHosts makes server dispatch requests to the virtual hosts by
+// their Host header, falling back to its own handler
+func (s *Server) serveVirtualHosts(server *http.Server) {
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	exact := make(map[string]http.Handler)
+	var wildcards []virtualHost
+	for _, vh := range s.virtualHosts {
+		host := strings.ToLower(vh.host)
+		if strings.HasPrefix(host, "*.") {
+			wildcards = append(wildcards, virtualHost{host: host[1:], handler: vh.handler})
+		} else if _, ok := exact[host]; !ok {
+			exact[host] = vh.handler
+		}
+	}
+
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(host, ".")
+
+		if h, ok := exact[host]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// The longest matching suffix wins
+		var (
+			best    http.Handler
+			bestLen int
+		)
+		for _, vh := range wildcards {
+			if strings.HasSuffix(host, vh.host) && len(host) > len(vh.host) && len(vh.host) > bestLen {
+				best, bestLen = vh.handler, len(vh.host)
+			}
+		}
+		if best != nil {
+			best.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
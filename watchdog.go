@@ -0,0 +1,35 @@
+package graceful
+
+import (
+	"os"
+	"runtime"
+)
+
+// watchdogStackSize bounds the goroutine dump logged by the watchdog
+const watchdogStackSize = 1 << 20
+
+// startExitWatchdog exits the process with ExitWatchdog if Serve hasn't
+// returned within forceExitAfter of the shutdown starting, logging every
+// goroutine's stack to show what hung
+func (s *Server) startExitWatchdog() {
+	if s.forceExitAfter <= 0 {
+		return
+	}
+
+	go func() {
+		timer := s.clock.NewTimer(s.forceExitAfter)
+		defer timer.Stop()
+
+		select {
+		case <-s.done:
+			return
+		case <-timer.C():
+		}
+
+		buf := make([]byte, watchdogStackSize)
+		buf = buf[:runtime.Stack(buf, true)]
+		s.logger.Errorf("Shutdown didn't finish within %s, exiting with code %d. Goroutines:\n%s", s.forceExitAfter, ExitWatchdog, buf)
+
+		os.Exit(ExitWatchdog)
+	}()
+}
@@ -0,0 +1,169 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// addWorker registers fn as an actor whose context is cancelled when the
+// shutdown reaches it. Errors are collected rather than returned, so every
+// worker's error is reported by Serve.
+func (s *Server) addWorker(fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a := &actor{
+		interrupt: func(error) { cancel() },
+		work:      fn,
+	}
+	a.execute = func() error {
+		err := s.runWorker(ctx, fn)
+		if err == nil {
+			return nil
+		}
+
+		s.logger.Errorf("Worker failed: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+
+		s.mu.Lock()
+		s.workerErrs = append(s.workerErrs, err)
+		s.mu.Unlock()
+		a.failed = err
+		s.setShutdownCause(CauseError)
+
+		return nil
+	}
+	s.actors = append(s.actors, a)
+}
+
+// runWorker runs fn until it fails, or until ctx is done and fn has returned
+// or the shutdown timeout has passed
+func (s *Server) runWorker(ctx context.Context, fn func(ctx context.Context) error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- fn(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			// A worker that is done doesn't stop the others
+			<-ctx.Done()
+			return nil
+		}
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+
+	case <-ctx.Done():
+	}
+
+	var timeout <-chan time.Time
+	if s.shutdownTimeout > 0 {
+		timer := s.clock.NewTimer(s.shutdownTimeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case err := <-result:
+		// Returning the cancellation is how a worker stops cleanly
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+
+	case <-timeout:
+		s.logger.Warnf("Worker didn't stop within %s, no longer waiting for it", s.shutdownTimeout)
+		return nil
+	}
+}
+
+// groupWorkers replaces the WithWorkers actors with a single one running
+// them in an errgroup, for WithErrGroupWorkers
+func (s *Server) groupWorkers() {
+	var (
+		actors []*actor
+		works  []func(ctx context.Context) error
+	)
+	for _, a := range s.actors {
+		if a.work != nil {
+			works = append(works, a.work)
+		} else {
+			actors = append(actors, a)
+		}
+	}
+	if len(works) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.actors = append(actors, &actor{
+		name:      "workers",
+		execute:   func() error { return s.runWorkerGroup(ctx, works) },
+		interrupt: func(error) { cancel() },
+	})
+}
+
+// runWorkerGroup runs works in an errgroup sharing a context derived from
+// ctx. The first error cancels it for all of them and is returned, shutting
+// the process down. Once ctx is done it waits for them as runWorker does,
+// collecting their errors.
+func (s *Server) runWorkerGroup(ctx context.Context, works []func(ctx context.Context) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, fn := range works {
+		fn := fn
+		g.Go(func() error { return fn(gctx) })
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- g.Wait()
+	}()
+
+	select {
+	case err := <-result:
+		if err == nil || (ctx.Err() != nil && errors.Is(err, context.Canceled)) {
+			// Workers that are done don't stop the servers
+			<-ctx.Done()
+			return nil
+		}
+
+		s.logger.Errorf("Worker failed, cancelling the others: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+		return err
+
+	case <-ctx.Done():
+	}
+
+	var timeout <-chan time.Time
+	if s.shutdownTimeout > 0 {
+		timer := s.clock.NewTimer(s.shutdownTimeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case err := <-result:
+		if err == nil || errors.Is(err, context.Canceled) {
+			return nil
+		}
+
+		s.logger.Errorf("Worker failed: %v", err)
+		s.emit(Event{Type: EventError, Err: err})
+
+		s.mu.Lock()
+		s.workerErrs = append(s.workerErrs, err)
+		s.mu.Unlock()
+
+		return nil
+
+	case <-timeout:
+		s.logger.Warnf("Workers didn't stop within %s, no longer waiting for them", s.shutdownTimeout)
+		return nil
+	}
+}